@@ -0,0 +1,715 @@
+package main
+
+// the commands of zreader, built on the zinterpreter package
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"zreader4/zinterpreter"
+)
+
+var diagnosticsFormats = []string{"text", "json", "sarif"}
+
+// -diagnostics and -werror
+type checkOptions struct {
+	diagnosticsFormat string
+	werror            bool
+}
+
+func (check *checkOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&check.diagnosticsFormat, "diagnostics", "text", "Format of the errors of the schema: "+strings.Join(diagnosticsFormats, ", "))
+	fs.BoolVar(&check.werror, "werror", false, "Exit with 3 when the schema has a warning")
+}
+
+func (check *checkOptions) check() string {
+	if !contains(diagnosticsFormats, check.diagnosticsFormat) {
+		return "-diagnostics must be one of " + strings.Join(diagnosticsFormats, ", ") + "."
+	}
+	return ""
+}
+
+// the progress messages are only printed with the text format
+func (check *checkOptions) text() bool {
+	return check.diagnosticsFormat == "text"
+}
+
+// -fix rewrites the schema files
+
+type fixOptions struct {
+	fix bool
+}
+
+func (fix *fixOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&fix.fix, "fix", false, "Rewrite the schema files, removing the subject types declared twice and declaring the missing definitions")
+}
+
+func (fix *fixOptions) check(in *inputOptions) string {
+	if fix.fix && (in.schema != "" || in.fschema == "-" || contains(in.files, "-")) {
+		return "-fix needs schema files, given with -fschema or as arguments."
+	}
+	return ""
+}
+
+// runFix writes the fixes in the files and reads the schema again, so that only what is left is reported
+// nothing is fixed in a schema with a syntax error
+func runFix(in *inputOptions, loaded *loadedSchema, check *checkOptions) (*loadedSchema, int) {
+	if loaded.syntaxError {
+		return loaded, exitOK
+	}
+	texts := make(map[string]string)
+	for _, src := range loaded.sources {
+		texts[src.filename] = src.input
+	}
+	fixes := zinterpreter.Fixes(loaded.schema, texts)
+	if len(fixes) == 0 {
+		return loaded, exitOK
+	}
+
+	var edits []zinterpreter.Edit
+	for _, fix := range fixes {
+		edits = append(edits, fix.Edits...)
+	}
+	for _, src := range loaded.sources {
+		fixed := zinterpreter.ApplyEdits(src.input, src.filename, edits)
+		if fixed == src.input {
+			continue
+		}
+		if err := rewriteFile(src.filename, fixed); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return loaded, exitIO
+		}
+	}
+
+	// the fixes are not diagnostics : with json or sarif they go to stderr
+	out := os.Stdout
+	if !check.text() {
+		out = os.Stderr
+	}
+	for _, fix := range fixes {
+		fmt.Fprintln(out, fix)
+	}
+	return readAndLoad(in)
+}
+
+// rewriteFile replaces the content of a file, keeping its permissions
+func rewriteFile(filename string, content string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("Erreur lors de l'écriture dans le fichier: %v", err)
+	}
+	if err := os.WriteFile(filename, []byte(content), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("Erreur lors de l'écriture dans le fichier: %v", err)
+	}
+	return nil
+}
+
+func writeOutFile(content string, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Erreur lors de la création du fichier: %v", err)
+	}
+	defer file.Close() // Assurer la fermeture du fichier à la fin du programme
+
+	_, err = file.WriteString(content)
+	if err != nil {
+		return fmt.Errorf("Erreur lors de l'écriture dans le fichier: %v", err)
+	}
+	return nil
+}
+
+func contains(slice []string, item string) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+// the diagnostics are written on stdout in the format of -diagnostics
+func writeDiagnostics(format string, diagnostics []zinterpreter.Diagnostic) {
+	var err error
+	switch format {
+	case "json":
+		err = zinterpreter.WriteDiagnosticsJSON(os.Stdout, diagnostics)
+	case "sarif":
+		err = zinterpreter.WriteDiagnosticsSARIF(os.Stdout, diagnostics)
+	default:
+		err = zinterpreter.WriteDiagnosticsText(os.Stdout, diagnostics)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erreur lors de l'écriture des diagnostics:", err)
+		os.Exit(exitIO)
+	}
+}
+
+// exitCode of the diagnostics : a syntax error first, then a semantic error, then a warning with -werror
+func exitCode(diagnostics []zinterpreter.Diagnostic, werror bool) int {
+	code := exitOK
+	for _, d := range diagnostics {
+		switch {
+		case d.Code == zinterpreter.CodeSyntax:
+			return exitSyntax
+		case d.Severity == zinterpreter.SeverityError:
+			code = exitSemantic
+		case werror && code == exitOK:
+			code = exitWarnings
+		}
+	}
+	return code
+}
+
+// zreader parse : syntax only
+
+var parseCommand = &command{
+	name:    "parse",
+	args:    "[file | directory | glob | -]...",
+	summary: "Check the syntax of the schema",
+	flags: func(fs *flag.FlagSet) func(args []string) int {
+		in := &inputOptions{}
+		in.register(fs)
+		check := &checkOptions{}
+		fs.StringVar(&check.diagnosticsFormat, "diagnostics", "text", "Format of the errors of the schema: "+strings.Join(diagnosticsFormats, ", "))
+		return func(args []string) int {
+			if message := firstOf(in.check(args), check.check()); message != "" {
+				return usage(fs, message)
+			}
+			sources, err := in.read()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitIO
+			}
+			var diagnostics []zinterpreter.Diagnostic
+			for _, src := range sources {
+				lexer := zinterpreter.NewFileLexer(src.filename, src.input)
+				lexer.NextToken()
+				_, err = lexer.ReadZSchema()
+				diagnostics = append(diagnostics, zinterpreter.SyntaxDiagnostics(err)...)
+			}
+			writeDiagnostics(check.diagnosticsFormat, diagnostics)
+			if len(diagnostics) > 0 {
+				return exitSyntax
+			}
+			if check.text() {
+				fmt.Println("parsed schema is done.")
+			}
+			return exitOK
+		}
+	},
+}
+
+// zreader validate : syntax and semantic, writes nothing
+
+var validateCommand = &command{
+	name:    "validate",
+	args:    "[file | directory | glob | -]...",
+	summary: "Check the schema without writing any file",
+	flags: func(fs *flag.FlagSet) func(args []string) int {
+		in := &inputOptions{}
+		in.register(fs)
+		check := &checkOptions{}
+		check.register(fs)
+		fix := &fixOptions{}
+		fix.register(fs)
+		return func(args []string) int {
+			if message := firstOf(in.check(args), check.check(), fix.check(in)); message != "" {
+				return usage(fs, message)
+			}
+			return runValidate(in, check, fix)
+		}
+	},
+}
+
+func runValidate(in *inputOptions, check *checkOptions, fix *fixOptions) int {
+	loaded, code := readAndLoad(in)
+	if code != exitOK {
+		return code
+	}
+	if fix.fix {
+		if loaded, code = runFix(in, loaded, check); code != exitOK {
+			return code
+		}
+	}
+	writeDiagnostics(check.diagnosticsFormat, loaded.diagnostics)
+	code = exitCode(loaded.diagnostics, check.werror)
+	if code == exitOK && check.text() {
+		fmt.Println("schema is valid.")
+	}
+	return code
+}
+
+// zreader render : the Archimate PlantUML diagram
+
+type renderOptions struct {
+	out        string
+	format     string
+	configFile string
+	flags      zinterpreter.RenderOptions // the options given as flags
+	skinParams skinParams
+	focus      string
+	depth      int
+	reverse    bool
+	split      bool
+	renderers  []zinterpreter.Renderer
+	options    zinterpreter.RenderOptions // the "render" part of the configuration file, then the flags
+}
+
+func (render *renderOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&render.out, "out", "out", "Generated file name, without its extension")
+	fs.StringVar(&render.format, "format", "plantuml", "Formats of the diagram, separated by commas: "+strings.Join(rendererNames(), ", "))
+	fs.BoolVar(&render.flags.HideDocs, "nodocs", false, "Do not draw the comments of the schema as notes")
+	fs.StringVar(&render.configFile, "config", "", "Configuration file with the render options")
+	fs.Float64Var(&render.flags.Scale, "scale", 0, "Scale of the plantUML diagram (default 1.0)")
+	fs.IntVar(&render.flags.Dpi, "dpi", 0, "Resolution of the plantUML and dot diagrams (default 96)")
+	fs.StringVar(&render.flags.Direction, "direction", "", "Layout direction: TB, LR, BT or RL, plantuml only draws TB and LR")
+	fs.StringVar(&render.flags.Title, "title", "", "Title written above the diagram")
+	fs.BoolVar(&render.flags.Legend, "legend", false, "Draw a legend in the plantUML diagram")
+	fs.StringVar(&render.flags.ErrorColor, "errorcolor", "", "Color of the errors, a name or #rrggbb (default red)")
+	fs.StringVar(&render.flags.WildcardColor, "wildcardcolor", "", "Color of the wildcard arrows, a name or #rrggbb")
+	fs.Var(&render.skinParams, "skinparam", "PlantUML skinparam as name=value, may be repeated")
+	fs.BoolVar(&render.flags.Sorted, "sorted", false, "Draw the definitions, relations, permissions and caveats in the order of their names")
+	fs.StringVar(&render.focus, "focus", "", "Draw only the neighborhood of a definition or of definition#relation")
+	fs.IntVar(&render.depth, "depth", 1, "With -focus, the number of hops from the focused definition")
+	fs.BoolVar(&render.reverse, "reverse", false, "With -focus, draw the definitions using the focused definition too")
+	fs.BoolVar(&render.split, "split", false, "Write in the directory -out one plantUML diagram per definition and a schema-index.puml linking them")
+}
+
+// check finds the renderer of every format of -format
+func (render *renderOptions) check(fs *flag.FlagSet) string {
+	render.renderers = nil
+	for _, name := range strings.Split(render.format, ",") {
+		renderer := zinterpreter.LookupRenderer(strings.TrimSpace(name))
+		if renderer == nil {
+			return "-format must be one or more of " + strings.Join(rendererNames(), ", ") + "."
+		}
+		render.renderers = append(render.renderers, renderer)
+	}
+	if render.split && (len(render.renderers) != 1 || render.renderers[0].Name() != "plantuml") {
+		return "-split only draws plantuml diagrams."
+	}
+	if render.split && render.focus != "" {
+		return "-split and -focus can not be used together."
+	}
+	return ""
+}
+
+// configure sets the options of the configuration file replaced by the flags given,
+// and returns the exit code of a configuration file or a flag that is wrong
+func (render *renderOptions) configure(fs *flag.FlagSet) int {
+	conf, err := readConfig(render.configFile)
+	if err != nil {
+		return configFailed(err)
+	}
+	options := conf.Render
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "nodocs":
+			options.HideDocs = render.flags.HideDocs
+		case "scale":
+			options.Scale = render.flags.Scale
+		case "dpi":
+			options.Dpi = render.flags.Dpi
+		case "direction":
+			options.Direction = render.flags.Direction
+		case "title":
+			options.Title = render.flags.Title
+		case "legend":
+			options.Legend = render.flags.Legend
+		case "errorcolor":
+			options.ErrorColor = render.flags.ErrorColor
+		case "wildcardcolor":
+			options.WildcardColor = render.flags.WildcardColor
+		case "sorted":
+			options.Sorted = render.flags.Sorted
+		case "skinparam":
+			merged := make(map[string]string)
+			for name, value := range options.SkinParams {
+				merged[name] = value
+			}
+			for name, value := range render.skinParams {
+				merged[name] = value
+			}
+			options.SkinParams = merged
+		}
+	})
+	options.Name = render.out
+	if err := options.Validate(); err != nil {
+		return usage(fs, err.Error()+".")
+	}
+	// plantUML only knows top to bottom and left to right
+	if options.Direction == "BT" || options.Direction == "RL" {
+		for _, renderer := range render.renderers {
+			if renderer.Name() == "plantuml" {
+				return usage(fs, "direction "+options.Direction+" can not be drawn by plantuml, only TB and LR.")
+			}
+		}
+	}
+	render.options = options
+	return exitOK
+}
+
+// -skinparam name=value, repeated
+type skinParams map[string]string
+
+func (params *skinParams) String() string {
+	var all []string
+	for name, value := range *params {
+		all = append(all, name+"="+value)
+	}
+	sort.Strings(all)
+	return strings.Join(all, ",")
+}
+
+func (params *skinParams) Set(text string) error {
+	name, value, found := strings.Cut(text, "=")
+	if !found {
+		return fmt.Errorf("%s is not name=value", text)
+	}
+	if *params == nil {
+		*params = make(skinParams)
+	}
+	(*params)[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	return nil
+}
+
+// plantuml (.puml), mermaid (.mmd)...
+func rendererNames() []string {
+	var names []string
+	for _, renderer := range zinterpreter.Renderers() {
+		names = append(names, fmt.Sprintf("%s (%s)", renderer.Name(), renderer.Extension()))
+	}
+	return names
+}
+
+var renderCommand = &command{
+	name:    "render",
+	args:    "[file | directory | glob | -]...",
+	summary: "Generate the diagrams of the schema, Archimate plantUML by default",
+	flags: func(fs *flag.FlagSet) func(args []string) int {
+		in := &inputOptions{}
+		in.register(fs)
+		render := &renderOptions{}
+		render.register(fs)
+		check := &checkOptions{}
+		check.register(fs)
+		return func(args []string) int {
+			if message := firstOf(in.check(args), render.check(fs), check.check()); message != "" {
+				return usage(fs, message)
+			}
+			if code := render.configure(fs); code != exitOK {
+				return code
+			}
+			return runRender(in, render, check)
+		}
+	},
+}
+
+// the diagram is drawn even with errors, but the exit code tells them
+func runRender(in *inputOptions, render *renderOptions, check *checkOptions) int {
+	loaded, code := readAndLoad(in)
+	if code != exitOK {
+		return code
+	}
+	if !loaded.syntaxError && check.text() {
+		fmt.Println("parsed schema is done.")
+	}
+	writeDiagnostics(check.diagnosticsFormat, loaded.diagnostics)
+
+	schema := loaded.schema
+	if render.focus != "" {
+		var err error
+		if schema, err = schema.Focus(render.focus, render.depth, render.reverse); err != nil {
+			fmt.Fprintln(os.Stderr, "-focus:", err)
+			return exitUsage
+		}
+	}
+	if render.split {
+		if code := runSplit(schema, render, check); code != exitOK {
+			return code
+		}
+		return exitCode(loaded.diagnostics, check.werror)
+	}
+	for _, renderer := range render.renderers {
+		filename := render.out + renderer.Extension()
+		if err := writeOutFile(renderer.Render(schema, render.options), filename); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
+		}
+		if check.text() {
+			fmt.Println("Generating " + filename + " is done.")
+		}
+	}
+	return exitCode(loaded.diagnostics, check.werror)
+}
+
+// -split : the directory -out, created if needed, gets schema-index.puml and a file per definition
+func runSplit(schema *zinterpreter.Schema, render *renderOptions, check *checkOptions) int {
+	if err := os.MkdirAll(render.out, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, "Erreur lors de la création du répertoire:", err)
+		return exitIO
+	}
+	files := zinterpreter.SplitPlantUML(schema, render.options)
+	for _, file := range files {
+		if err := writeOutFile(file.Content, filepath.Join(render.out, file.Name)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
+		}
+	}
+	if check.text() {
+		fmt.Printf("Generating %d files in %s is done.\n", len(files), render.out)
+	}
+	return exitOK
+}
+
+// zreader fmt : prints the canonical schema, rewrites the file with -w or prints a diff with -d
+
+type fmtOptions struct {
+	write bool
+	diff  bool
+}
+
+func (format *fmtOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&format.write, "w", false, "Rewrite the schema files formatted")
+	fs.BoolVar(&format.diff, "d", false, "Print a diff and exit with 6 when the schema is not formatted")
+}
+
+func (format *fmtOptions) check(in *inputOptions) string {
+	if format.write && (in.schema != "" || in.fschema == "-" || contains(in.files, "-")) {
+		return "-w needs schema files, given with -fschema or as arguments."
+	}
+	return ""
+}
+
+var fmtCommand = &command{
+	name:    "fmt",
+	args:    "[file | directory | glob | -]...",
+	summary: "Print the schema in its canonical form",
+	flags: func(fs *flag.FlagSet) func(args []string) int {
+		in := &inputOptions{}
+		in.register(fs)
+		format := &fmtOptions{}
+		format.register(fs)
+		return func(args []string) int {
+			if message := firstOf(in.check(args), format.check(in)); message != "" {
+				return usage(fs, message)
+			}
+			return runFormat(in, format)
+		}
+	},
+}
+
+// every file is formatted on its own
+func runFormat(in *inputOptions, format *fmtOptions) int {
+	sources, err := in.read()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	code := exitOK
+	for _, src := range sources {
+		switch formatSource(src, format) {
+		case exitIO:
+			return exitIO
+		case exitSyntax:
+			code = exitSyntax
+		case exitDifferent:
+			if code == exitOK {
+				code = exitDifferent
+			}
+		}
+	}
+	return code
+}
+
+func formatSource(src source, format *fmtOptions) int {
+	input, filename := src.input, src.filename
+	lexer := zinterpreter.NewFileLexer(filename, input)
+	lexer.NextToken()
+	zschema, err := lexer.ReadZSchema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "syntax error:")
+		fmt.Fprintln(os.Stderr, err)
+		return exitSyntax
+	}
+
+	formatted := zinterpreter.Format(zschema, lexer.ZCaveats(), lexer.EndDoc())
+	// keep the line endings of the file
+	if strings.Contains(input, "\r\n") {
+		formatted = strings.ReplaceAll(formatted, "\n", "\r\n")
+	}
+
+	switch {
+	case format.diff:
+		// the same test as -w, so that -d fails on every file -w rewrites
+		if formatted != input {
+			fmt.Print(zinterpreter.UnifiedDiff(filename, filename+" (formatted)", input, formatted))
+			return exitDifferent
+		}
+	case format.write:
+		if formatted == input {
+			return exitOK
+		}
+		if err := rewriteFile(filename, formatted); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
+		}
+		fmt.Println("Formatting " + filename + " is done.")
+	default:
+		fmt.Print(formatted)
+	}
+	return exitOK
+}
+
+// zreader diff old new : the definitions, relations, permissions and caveats changed
+
+var diffCommand = &command{
+	name:    "diff",
+	args:    "old new",
+	summary: "Print the semantic changes between two schemas",
+	flags: func(fs *flag.FlagSet) func(args []string) int {
+		return func(args []string) int {
+			if len(args) != 2 {
+				return usage(fs, "diff needs two schemas, as files, directories or globs.")
+			}
+			var schemas []*loadedSchema
+			for _, filename := range args {
+				loaded, code := readAndLoad(&inputOptions{files: []string{filename}})
+				if code != exitOK {
+					return code
+				}
+				if loaded.syntaxError {
+					writeDiagnostics("text", loaded.diagnostics)
+					return exitSyntax
+				}
+				schemas = append(schemas, loaded)
+			}
+			changes := zinterpreter.DiffSchemas(schemas[0].schema, schemas[1].schema)
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+			if len(changes) > 0 {
+				return exitDifferent
+			}
+			return exitOK
+		}
+	},
+}
+
+// zreader lint : every diagnostic, the warnings included, fails the check
+
+var lintCommand = &command{
+	name:    "lint",
+	args:    "[file | directory | glob | -]...",
+	summary: "Check the schema with the lint rules and fail on warnings too",
+	flags: func(fs *flag.FlagSet) func(args []string) int {
+		in := &inputOptions{}
+		in.register(fs)
+		check := &checkOptions{werror: true}
+		fs.StringVar(&check.diagnosticsFormat, "diagnostics", "text", "Format of the errors of the schema: "+strings.Join(diagnosticsFormats, ", "))
+		var configFile string
+		var listRules bool
+		fs.StringVar(&configFile, "config", "", "Configuration file enabling, disabling or setting the severity of the lint rules")
+		fs.BoolVar(&listRules, "rules", false, "List the lint rules")
+		fix := &fixOptions{}
+		fix.register(fs)
+		return func(args []string) int {
+			if listRules {
+				for _, rule := range zinterpreter.LintRules() {
+					fmt.Printf("  %-19s %s\n", rule[0], rule[1])
+				}
+				return exitOK
+			}
+			if message := firstOf(in.check(args), check.check(), fix.check(in)); message != "" {
+				return usage(fs, message)
+			}
+			conf, err := readConfig(configFile)
+			if err != nil {
+				return configFailed(err)
+			}
+
+			loaded, code := readAndLoad(in)
+			if code != exitOK {
+				return code
+			}
+			if fix.fix {
+				if loaded, code = runFix(in, loaded, check); code != exitOK {
+					return code
+				}
+			}
+			diagnostics := loaded.diagnostics
+			if !loaded.syntaxError {
+				diagnostics = append(diagnostics, zinterpreter.Lint(loaded.schema, conf.Lint)...)
+			}
+			writeDiagnostics(check.diagnosticsFormat, diagnostics)
+			code = exitCode(diagnostics, check.werror)
+			if code == exitOK && check.text() {
+				fmt.Println("schema is valid.")
+			}
+			return code
+		}
+	},
+}
+
+// zreader -schema ... or -fschema ... : render, or fmt with -fmt, or validate with -validate
+
+func runLegacy(args []string) int {
+	legacy := &command{name: "", summary: "Generate the Archimate plantUML diagram of the schema"}
+	in := &inputOptions{}
+	render := &renderOptions{}
+	check := &checkOptions{}
+	format := &fmtOptions{}
+	var formatMode, validate bool
+	legacy.flags = func(fs *flag.FlagSet) func(args []string) int {
+		in.register(fs)
+		render.register(fs)
+		check.register(fs)
+		format.register(fs)
+		fs.BoolVar(&formatMode, "fmt", false, "Print the schema formatted instead of generating the plantUML file, as zreader fmt")
+		fs.BoolVar(&validate, "validate", false, "Only check the schema, without writing the plantUML file, as zreader validate")
+		fs.Bool("help", false, "Show help message")
+		return func(args []string) int {
+			if message := firstOf(in.check(args), render.check(fs), check.check(), format.check(in)); message != "" {
+				return usage(fs, message)
+			}
+			if code := render.configure(fs); code != exitOK {
+				return code
+			}
+			switch {
+			case formatMode:
+				return runFormat(in, format)
+			case validate:
+				return runValidate(in, check, &fixOptions{})
+			default:
+				return runRender(in, render, check)
+			}
+		}
+	}
+	fs, run := legacy.flagSet()
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			printHelp()
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.Lookup("help").Value.String() == "true" {
+		printHelp()
+		return exitOK
+	}
+	return run(positional)
+}
+
+// firstOf returns the first error message
+func firstOf(messages ...string) string {
+	for _, message := range messages {
+		if message != "" {
+			return message
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the schemas of the command tests
+var testSchemas = map[string]string{
+	"valid.zed":       "definition user {}\n\ndefinition document {\n\trelation viewer: user\n\n\tpermission view = viewer\n}\n",
+	"semantic.zed":    "definition document {\n\trelation viewer: usr\n}\n",
+	"syntax.zed":      "definition document {\n\trelation viewer user\n}\n",
+	"unformatted.zed": "definition   user {}\n",
+	"warning.zed":     "definition user {}\n\ndefinition document {\n\trelation viewer: user | user\n}\n",
+	"bottom.json":     "{ \"render\": { \"direction\": \"BT\" } }",
+	"config.json":     "{ \"render\": { \"scale\": 0.5 } }",
+	"malformed.json":  "{ \"unknown\": true }",
+}
+
+// runQuiet runs zreader with the arguments, without writing on stdout or stderr, and returns the exit code
+func runQuiet(t *testing.T, args ...string) int {
+	t.Helper()
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = null, null
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	return run(args)
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	dir := writeFiles(t, testSchemas)
+	in := func(name string) string { return filepath.Join(dir, name) }
+	out := filepath.Join(dir, "out")
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{"parse valid", []string{"parse", in("valid.zed")}, exitOK},
+		{"parse semantic error", []string{"parse", in("semantic.zed")}, exitOK},
+		{"parse syntax error", []string{"parse", in("syntax.zed")}, exitSyntax},
+		{"parse missing file", []string{"parse", in("missing.zed")}, exitIO},
+		{"parse bad flag", []string{"parse", "-unknown", in("valid.zed")}, exitUsage},
+
+		{"validate valid", []string{"validate", in("valid.zed")}, exitOK},
+		{"validate semantic error", []string{"validate", in("semantic.zed")}, exitSemantic},
+		{"validate syntax error", []string{"validate", in("syntax.zed")}, exitSyntax},
+		{"validate missing file", []string{"validate", in("missing.zed")}, exitIO},
+		{"validate bad flag", []string{"validate", "-diagnostics", "xml", in("valid.zed")}, exitUsage},
+
+		{"render valid", []string{"render", "-out", out, in("valid.zed")}, exitOK},
+		{"render semantic error", []string{"render", "-out", out, in("semantic.zed")}, exitSemantic},
+		{"render syntax error", []string{"render", "-out", out, in("syntax.zed")}, exitSyntax},
+		{"render missing file", []string{"render", "-out", out, in("missing.zed")}, exitIO},
+		{"render bad flag", []string{"render", "-format", "svg", in("valid.zed")}, exitUsage},
+		{"render config", []string{"render", "-out", out, "-config", in("config.json"), in("valid.zed")}, exitOK},
+		{"render missing config", []string{"render", "-out", out, "-config", in("missing.json"), in("valid.zed")}, exitIO},
+		{"render malformed config", []string{"render", "-out", out, "-config", in("malformed.json"), in("valid.zed")}, exitUsage},
+		{"render direction", []string{"render", "-out", out, "-direction", "LR", in("valid.zed")}, exitOK},
+		{"render plantuml bottom to top", []string{"render", "-out", out, "-direction", "BT", in("valid.zed")}, exitUsage},
+		{"render plantuml right to left", []string{"render", "-out", out, "-format", "dot,plantuml", "-direction", "RL", in("valid.zed")}, exitUsage},
+		{"render plantuml configured bottom to top", []string{"render", "-out", out, "-config", in("bottom.json"), in("valid.zed")}, exitUsage},
+		{"render dot bottom to top", []string{"render", "-out", out, "-format", "dot,mermaid", "-direction", "BT", in("valid.zed")}, exitOK},
+		{"render unwritable file", []string{"render", "-out", in("missing/out"), in("valid.zed")}, exitIO},
+
+		{"fmt valid", []string{"fmt", in("valid.zed")}, exitOK},
+		{"fmt formatted", []string{"fmt", "-d", in("valid.zed")}, exitOK},
+		{"fmt not formatted", []string{"fmt", "-d", in("unformatted.zed"), in("valid.zed")}, exitDifferent},
+		{"fmt syntax error", []string{"fmt", in("syntax.zed")}, exitSyntax},
+		{"fmt missing file", []string{"fmt", in("missing.zed")}, exitIO},
+		{"fmt bad flag", []string{"fmt", "-unknown", in("valid.zed")}, exitUsage},
+
+		{"diff same schemas", []string{"diff", in("valid.zed"), in("valid.zed")}, exitOK},
+		{"diff different schemas", []string{"diff", in("valid.zed"), in("semantic.zed")}, exitDifferent},
+		{"diff syntax error", []string{"diff", in("valid.zed"), in("syntax.zed")}, exitSyntax},
+		{"diff missing file", []string{"diff", in("valid.zed"), in("missing.zed")}, exitIO},
+		{"diff one schema", []string{"diff", in("valid.zed")}, exitUsage},
+
+		{"lint valid", []string{"lint", in("valid.zed")}, exitOK},
+		{"lint semantic error", []string{"lint", in("semantic.zed")}, exitSemantic},
+		{"lint syntax error", []string{"lint", in("syntax.zed")}, exitSyntax},
+		{"lint missing file", []string{"lint", in("missing.zed")}, exitIO},
+		{"lint bad flag", []string{"lint", "-unknown", in("valid.zed")}, exitUsage},
+		{"lint missing config", []string{"lint", "-config", in("missing.json"), in("valid.zed")}, exitIO},
+		{"lint malformed config", []string{"lint", "-config", in("malformed.json"), in("valid.zed")}, exitUsage},
+
+		{"legacy valid", []string{"-fschema", in("valid.zed"), "-validate"}, exitOK},
+		{"legacy missing file", []string{"-fschema", in("missing.zed"), "-validate"}, exitIO},
+		{"legacy bad flag", []string{"-fschema", in("valid.zed"), "-schema", "definition user {}"}, exitUsage},
+
+		{"no command", nil, exitUsage},
+		{"unknown command", []string{"draw", in("valid.zed")}, exitUsage},
+		{"help", []string{"help", "lint"}, exitOK},
+	}
+	for _, test := range tests {
+		if code := runQuiet(t, test.args...); code != test.expected {
+			t.Errorf("%s: expected the exit code %d, got %d", test.name, test.expected, code)
+		}
+	}
+}
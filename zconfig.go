@@ -0,0 +1,64 @@
+package main
+
+// the configuration file given with -config
+//
+//	{
+//	  "lint": { "rules": { "sensitive-wildcard": { "types": ["admin"] } } },
+//	  "render": { "scale": 0.15, "dpi": 300 }
+//	}
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"zreader4/zinterpreter"
+)
+
+type config struct {
+	Lint   zinterpreter.LintConfig    `json:"lint"`
+	Render zinterpreter.RenderOptions `json:"render"`
+}
+
+// configReadError is a configuration file that can not be read,
+// the other errors of readConfig are a malformed file
+type configReadError struct {
+	err error
+}
+
+func (e configReadError) Error() string {
+	return fmt.Sprintf("Erreur lors de la lecture du fichier : %v", e.err)
+}
+
+// configFailed prints an error of readConfig and returns its exit code
+func configFailed(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	if _, ok := err.(configReadError); ok {
+		return exitIO
+	}
+	return exitUsage
+}
+
+// readConfig returns an empty configuration without file
+func readConfig(path string) (*config, error) {
+	conf := &config{}
+	if path == "" {
+		return conf, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, configReadError{err}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(conf); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := conf.Lint.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := conf.Render.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: render: %v", path, err)
+	}
+	return conf, nil
+}
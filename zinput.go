@@ -0,0 +1,198 @@
+package main
+
+// the schemas read by the commands : an inline schema, files, directories, globs or stdin
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"zreader4/zinterpreter"
+)
+
+// -schema, -fschema or the files given as arguments
+// "-" reads stdin, a directory gives every .zed file inside, a glob gives every file matching it
+type inputOptions struct {
+	schema  string
+	fschema string
+	files   []string
+}
+
+func (in *inputOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&in.schema, "schema", "", "Read schema")
+	fs.StringVar(&in.fschema, "fschema", "", "Read schema file, - for stdin")
+}
+
+// check takes the arguments of the command as the files
+func (in *inputOptions) check(args []string) string {
+	in.files = args
+	given := 0
+	for _, ok := range []bool{in.schema != "", in.fschema != "", len(in.files) > 0} {
+		if ok {
+			given++
+		}
+	}
+	if given != 1 {
+		return "you must provide either -schema, -fschema or schema files, but only one of them."
+	}
+	return ""
+}
+
+// a schema text and its file name for the positions
+type source struct {
+	input    string
+	filename string
+}
+
+// read returns every schema text in the order of the arguments
+func (in *inputOptions) read() ([]source, error) {
+	if in.schema != "" {
+		return []source{{in.schema, "<schema>"}}, nil
+	}
+	paths := []string{in.fschema}
+	if len(in.files) > 0 {
+		var err error
+		if paths, err = expandPaths(in.files); err != nil {
+			return nil, err
+		}
+	}
+
+	var sources []source
+	for _, path := range paths {
+		var fileContent []byte
+		var err error
+		filename := path
+		if path == "-" {
+			filename = "<stdin>"
+			fileContent, err = io.ReadAll(os.Stdin)
+		} else {
+			fileContent, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Erreur lors de la lecture du fichier : %v", err)
+		}
+		sources = append(sources, source{string(fileContent), filename})
+	}
+	return sources, nil
+}
+
+// expandPaths replaces the directories by their .zed files and the globs by the files and directories matching them
+// a file given twice is read once
+func expandPaths(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, arg := range args {
+		if arg == "-" {
+			add(arg)
+			continue
+		}
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %s: %v", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no schema file matches %s", arg)
+			}
+			for _, match := range matches {
+				files, err := schemaFiles(match)
+				if err != nil {
+					return nil, err
+				}
+				for _, file := range files {
+					add(file)
+				}
+			}
+			continue
+		}
+		files, err := schemaFiles(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			add(file)
+		}
+	}
+	return paths, nil
+}
+
+// schemaFiles returns the file path, or the .zed files of the directory path and its subdirectories, sorted
+func schemaFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Erreur lors de la lecture du fichier : %v", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && filepath.Ext(file) == ".zed" {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Erreur lors de la lecture du répertoire : %v", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no schema file in %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// the schemas read, concatenated and compiled as one schema
+type loadedSchema struct {
+	sources     []source
+	zdefs       []*zinterpreter.ZDef
+	zcaveats    []*zinterpreter.ZCaveat
+	syntaxError bool
+	schema      *zinterpreter.Schema
+	diagnostics []zinterpreter.Diagnostic // syntax then semantic
+}
+
+// parse each source with its own file name, so that every position tells its file
+func loadSchema(sources []source) *loadedSchema {
+	loaded := &loadedSchema{sources: sources}
+	for _, src := range sources {
+		lexer := zinterpreter.NewFileLexer(src.filename, src.input)
+		lexer.NextToken()
+		zdefs, err := lexer.ReadZSchema()
+		if err != nil {
+			loaded.syntaxError = true
+		}
+		loaded.zdefs = append(loaded.zdefs, zdefs...)
+		loaded.zcaveats = append(loaded.zcaveats, lexer.ZCaveats()...)
+		loaded.diagnostics = append(loaded.diagnostics, zinterpreter.SyntaxDiagnostics(err)...)
+	}
+
+	var semantic []zinterpreter.Diagnostic
+	loaded.schema, semantic = zinterpreter.Compile(loaded.zdefs, loaded.zcaveats)
+	loaded.diagnostics = append(loaded.diagnostics, semantic...)
+	return loaded
+}
+
+// readAndLoad reads the input, returns exitIO when a file can not be read
+func readAndLoad(in *inputOptions) (*loadedSchema, int) {
+	sources, err := in.read()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitIO
+	}
+	return loadSchema(sources), exitOK
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files, by their path in a temporary directory, and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.zed":           "definition a {}",
+		"b.zed":           "definition b {}",
+		"notes.txt":       "not a schema",
+		"schemas/c.zed":   "definition c {}",
+		"schemas/d/e.zed": "definition e {}",
+		"empty/notes.txt": "not a schema",
+	})
+	in := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+		err      string
+	}{
+		{"file", []string{in("a.zed")}, []string{in("a.zed")}, ""},
+		{"file given twice", []string{in("a.zed"), in("a.zed")}, []string{in("a.zed")}, ""},
+		{"directory", []string{in("schemas")}, []string{in("schemas/c.zed"), in("schemas/d/e.zed")}, ""},
+		{"glob", []string{in("*.zed")}, []string{in("a.zed"), in("b.zed")}, ""},
+		{"glob of a directory", []string{in("sch*")}, []string{in("schemas/c.zed"), in("schemas/d/e.zed")}, ""},
+		{"stdin", []string{"-"}, []string{"-"}, ""},
+		{"in the order of the arguments", []string{in("b.zed"), "-", in("a.zed")}, []string{in("b.zed"), "-", in("a.zed")}, ""},
+		{"glob with no match", []string{in("*.yaml")}, nil, "no schema file matches"},
+		{"missing file", []string{in("missing.zed")}, nil, "Erreur lors de la lecture du fichier"},
+		{"directory without schema", []string{in("empty")}, nil, "no schema file in"},
+		{"bad pattern", []string{in("[")}, nil, "bad pattern"},
+	}
+	for _, test := range tests {
+		paths, err := expandPaths(test.args)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected the error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: did not expect an error: %v", test.name, err)
+			continue
+		}
+		if strings.Join(paths, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, paths)
+		}
+	}
+}
+
+func TestReadMergesFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"user.zed":     "definition user {}",
+		"document.zed": "definition document {\n\trelation viewer: user\n\trelation owner: usr\n}",
+	})
+	in := &inputOptions{files: []string{filepath.Join(dir, "user.zed"), filepath.Join(dir, "document.zed")}}
+	loaded, code := readAndLoad(in)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d", exitOK, code)
+	}
+	if len(loaded.sources) != 2 || loaded.schema.Definition("user") == nil || loaded.schema.Definition("document") == nil {
+		t.Fatalf("expected the definitions of both files, got %v", loaded.schema.Definitions)
+	}
+	// user of the first file is known in the second, usr is reported in the second file
+	if len(loaded.diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", loaded.diagnostics)
+	}
+	expected := filepath.Join(dir, "document.zed") + ":3:"
+	if !strings.HasPrefix(loaded.diagnostics[0].String(), expected) {
+		t.Errorf("expected the diagnostic in %s, got %s", expected, loaded.diagnostics[0])
+	}
+}
+
+func TestReadStdin(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"stdin.zed": "definition document {\n\trelation viewer: user\n}",
+		"user.zed":  "definition user {}",
+	})
+	stdin, err := os.Open(filepath.Join(dir, "stdin.zed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+
+	in := &inputOptions{files: []string{"-", filepath.Join(dir, "user.zed")}}
+	sources, err := in.read()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+	if len(sources) != 2 || sources[0].filename != "<stdin>" || !strings.Contains(sources[0].input, "definition document") {
+		t.Fatalf("expected stdin then user.zed, got %v", sources)
+	}
+	if loaded := loadSchema(sources); len(loaded.diagnostics) != 0 {
+		t.Errorf("expected no diagnostic, got %v", loaded.diagnostics)
+	}
+}
@@ -0,0 +1,367 @@
+package zinterpreter
+
+// Semantic analysis
+//
+// Compile resolves every name of the parsed definitions and caveats
+// into a Schema where relations, subjects and permissions point to what they use.
+// Every problem found is returned as a Diagnostic, the Schema is always built
+// so that the generators draw what they can.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Schema is a compiled schema
+type Schema struct {
+	Definitions []*Definition
+	Caveats     []*Caveat
+
+	definitionMap map[string]*Definition
+	caveatMap     map[string]*Caveat
+}
+
+// definition name { ... }
+// a Duplicate definition has the name of a previous one, the names used in the schema never refer to it
+type Definition struct {
+	Source      *ZDef
+	Relations   []*Relation
+	Permissions []*Permission
+	Duplicate   bool
+
+	relationMap   map[string]*Relation
+	permissionMap map[string]*Permission
+}
+
+// relation name: subject | subject ...
+// a Duplicate relation has the name of a previous relation of its definition
+type Relation struct {
+	Source     *ZRelation
+	Definition *Definition
+	Subjects   []*Subject
+	Duplicate  bool
+}
+
+// SubjectKind is the kind of a subject type of a relation
+type SubjectKind int
+
+const (
+	ObjectSubject   SubjectKind = iota // object
+	SubjectSet                         // object#relation
+	WildcardSubject                    // object:*
+)
+
+// subject type of a relation
+// Target is nil when the definition does not exist, TargetRelation is nil when object#relation does not exist
+// a Duplicate subject is written more than once in its relation
+type Subject struct {
+	Kind           SubjectKind
+	Name           string // as written, with its prefix if any
+	RelationName   string // object#relation
+	CaveatName     string
+	Span           Span
+	Target         *Definition
+	TargetRelation *Relation
+	Caveat         *Caveat
+	Duplicate      bool
+}
+
+// permission name = expression
+// a Duplicate permission has the name of a previous relation or permission of its definition
+type Permission struct {
+	Source     *ZPermission
+	Definition *Definition
+	References []*Reference
+	Arrows     []*Arrow
+	Duplicate  bool
+}
+
+// a relation or a permission used by a permission expression
+// Relation and Permission are nil when the name does not exist in the definition
+type Reference struct {
+	Source     *ZExprName
+	Relation   *Relation
+	Permission *Permission
+}
+
+// relation->name used by a permission expression
+// Relation is nil when it is not a relation of the definition
+// MissingIn are the subject types of Relation in which name does not exist
+type Arrow struct {
+	Source    *ZExprArrow
+	Relation  *Relation
+	MissingIn []*Definition
+}
+
+// caveat name(parameters) { expression }
+// a Duplicate caveat has the name of a previous one
+type Caveat struct {
+	Source    *ZCaveat
+	Duplicate bool
+}
+
+func (d *Definition) Name() string {
+	return d.Source.FullName()
+}
+
+func (r *Relation) Name() string {
+	return r.Source.Name
+}
+
+func (p *Permission) Name() string {
+	return p.Source.Name
+}
+
+func (c *Caveat) Name() string {
+	return c.Source.Name
+}
+
+// Label is the subject type as written in the schema, without its caveat
+func (s *Subject) Label() string {
+	switch s.Kind {
+	case SubjectSet:
+		return s.Name + "#" + s.RelationName
+	case WildcardSubject:
+		return s.Name + ":*"
+	default:
+		return s.Name
+	}
+}
+
+// Resolved is true when every name of the subject type exists
+func (s *Subject) Resolved() bool {
+	return s.Target != nil && (s.Kind != SubjectSet || s.TargetRelation != nil) && (s.CaveatName == "" || s.Caveat != nil)
+}
+
+// Resolved is true when the name exists in the definition
+func (r *Reference) Resolved() bool {
+	return r.Relation != nil || r.Permission != nil
+}
+
+// Definition returns the definition named prefix/name, or nil
+func (s *Schema) Definition(name string) *Definition {
+	return s.definitionMap[name]
+}
+
+// Caveat returns the caveat named name, or nil
+func (s *Schema) Caveat(name string) *Caveat {
+	return s.caveatMap[name]
+}
+
+// Relation returns the relation named name, or nil
+func (d *Definition) Relation(name string) *Relation {
+	return d.relationMap[name]
+}
+
+// Permission returns the permission named name, or nil
+func (d *Definition) Permission(name string) *Permission {
+	return d.permissionMap[name]
+}
+
+// a name without prefix used in a prefixed definition is first searched with the same prefix
+// prefix/document { relation viewer: user } uses prefix/user if it exists, else user
+func (s *Schema) resolveDefinition(name string, from *Definition) *Definition {
+	if from != nil && from.Source.Prefix != "" && !strings.Contains(name, "/") {
+		if d := s.Definition(from.Source.Prefix + "/" + name); d != nil {
+			return d
+		}
+	}
+	return s.Definition(name)
+}
+
+type compiler struct {
+	schema      *Schema
+	diagnostics []Diagnostic
+}
+
+// related are the definitions involved in the problem
+func (c *compiler) report(code string, severity Severity, span Span, related []*Definition, format string, args ...interface{}) {
+	diagnostic := Diagnostic{Code: code, Severity: severity, Message: fmt.Sprintf(format, args...), Span: span}
+	for _, d := range related {
+		diagnostic.Related = append(diagnostic.Related, d.Name())
+	}
+	c.diagnostics = append(c.diagnostics, diagnostic)
+}
+
+// suggest adds the suggestions to the last diagnostic reported
+func (c *compiler) suggest(suggestions []string) {
+	last := &c.diagnostics[len(c.diagnostics)-1]
+	last.Suggestions = suggestions
+	last.Message += DidYouMean(suggestions)
+}
+
+// Compile resolves the definitions and the caveats read by ReadZSchema
+func Compile(zdefs []*ZDef, zcaveats []*ZCaveat) (*Schema, []Diagnostic) {
+	c := &compiler{schema: &Schema{
+		definitionMap: make(map[string]*Definition),
+		caveatMap:     make(map[string]*Caveat),
+	}}
+
+	c.declareCaveats(zcaveats)
+	c.declareDefinitions(zdefs)
+	for _, d := range c.schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		for _, r := range d.Relations {
+			c.resolveSubjects(r)
+		}
+	}
+	for _, d := range c.schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		for _, p := range d.Permissions {
+			if !p.Duplicate {
+				c.resolveExpression(p)
+			}
+		}
+	}
+	return c.schema, c.diagnostics
+}
+
+func (c *compiler) declareCaveats(zcaveats []*ZCaveat) {
+	for _, zcaveat := range zcaveats {
+		caveat := &Caveat{Source: zcaveat}
+		if _, exists := c.schema.caveatMap[zcaveat.Name]; exists {
+			caveat.Duplicate = true
+			c.report(CodeDuplicateCaveat, SeverityError, zcaveat.Span, nil, "caveat %s is declared more than once", zcaveat.Name)
+		} else {
+			c.schema.caveatMap[zcaveat.Name] = caveat
+		}
+		c.schema.Caveats = append(c.schema.Caveats, caveat)
+	}
+}
+
+// a relation name and a permission name must be unique in their definition
+func (c *compiler) declareDefinitions(zdefs []*ZDef) {
+	for _, zdef := range zdefs {
+		d := &Definition{
+			Source:        zdef,
+			relationMap:   make(map[string]*Relation),
+			permissionMap: make(map[string]*Permission),
+		}
+		if _, exists := c.schema.definitionMap[zdef.FullName()]; exists {
+			d.Duplicate = true
+			c.report(CodeDuplicateDefinition, SeverityError, zdef.Span, []*Definition{c.schema.definitionMap[zdef.FullName()]}, "definition %s is declared more than once", zdef.FullName())
+		} else {
+			c.schema.definitionMap[zdef.FullName()] = d
+		}
+		c.schema.Definitions = append(c.schema.Definitions, d)
+
+		for _, zrel := range zdef.Relations {
+			r := &Relation{Source: zrel, Definition: d}
+			if _, exists := d.relationMap[zrel.Name]; exists {
+				r.Duplicate = true
+				c.report(CodeDuplicateRelation, SeverityError, zrel.Span, []*Definition{d}, "relation %s is declared more than once in definition %s", zrel.Name, zdef.FullName())
+			} else {
+				d.relationMap[zrel.Name] = r
+			}
+			d.Relations = append(d.Relations, r)
+		}
+
+		for _, zperm := range zdef.Permissions {
+			p := &Permission{Source: zperm, Definition: d}
+			_, isRelation := d.relationMap[zperm.Name]
+			_, isPermission := d.permissionMap[zperm.Name]
+			if isRelation || isPermission {
+				p.Duplicate = true
+				c.report(CodeDuplicatePermission, SeverityError, zperm.Span, []*Definition{d}, "permission %s is declared more than once in definition %s", zperm.Name, zdef.FullName())
+			} else {
+				d.permissionMap[zperm.Name] = p
+			}
+			d.Permissions = append(d.Permissions, p)
+		}
+	}
+}
+
+// object, object#relation and object:* must exist, and be unique in the relation
+func (c *compiler) resolveSubjects(r *Relation) {
+	d := r.Definition
+	zrel := r.Source
+
+	for _, zobject := range zrel.Zobjects {
+		r.Subjects = append(r.Subjects, &Subject{Kind: ObjectSubject, Name: zobject.Name, CaveatName: zobject.Caveat, Span: zobject.Span})
+	}
+	for _, zobjectSet := range zrel.ZobjectSets {
+		r.Subjects = append(r.Subjects, &Subject{Kind: SubjectSet, Name: zobjectSet.Name, RelationName: zobjectSet.Relation, CaveatName: zobjectSet.Caveat, Span: zobjectSet.Span})
+	}
+	for _, zobjectWildCard := range zrel.ZobjectWildCards {
+		r.Subjects = append(r.Subjects, &Subject{Kind: WildcardSubject, Name: zobjectWildCard.Name, CaveatName: zobjectWildCard.Caveat, Span: zobjectWildCard.Span})
+	}
+
+	declared := make(map[string]bool)
+	for _, s := range r.Subjects {
+		s.Target = c.schema.resolveDefinition(s.Name, d)
+		if s.Target == nil {
+			c.report(CodeUnknownDefinition, SeverityError, s.Span, []*Definition{d}, "definition %s used in relation %s of definition %s does not exist", s.Name, zrel.Name, d.Name())
+			c.suggest(c.schema.SuggestDefinitions(s.Name, d))
+		} else if s.Kind == SubjectSet {
+			s.TargetRelation = s.Target.Relation(s.RelationName)
+			if s.TargetRelation == nil {
+				c.report(CodeUnknownRelation, SeverityError, s.Span, []*Definition{d, s.Target}, "relation %s used in relation %s of definition %s does not exist in %s", s.RelationName, zrel.Name, d.Name(), s.Target.Name())
+				c.suggest(s.Target.SuggestRelations(s.RelationName))
+			}
+		}
+
+		if s.CaveatName != "" {
+			s.Caveat = c.schema.Caveat(s.CaveatName)
+			if s.Caveat == nil {
+				c.report(CodeUnknownCaveat, SeverityError, s.Span, []*Definition{d}, "caveat %s used in relation %s of definition %s does not exist", s.CaveatName, zrel.Name, d.Name())
+				c.suggest(c.schema.SuggestCaveats(s.CaveatName))
+			}
+		}
+
+		key := withCaveat(s.Label(), s.CaveatName)
+		if declared[key] {
+			s.Duplicate = true
+			c.report(CodeDuplicateSubject, SeverityWarning, s.Span, []*Definition{d}, "%s is declared more than once in relation %s of definition %s", key, zrel.Name, d.Name())
+		}
+		declared[key] = true
+	}
+}
+
+// a name used by a permission is a relation or a permission of the same definition
+// relation->name : relation is a relation of the same definition
+// and name exists in every subject type of relation
+func (c *compiler) resolveExpression(p *Permission) {
+	d := p.Definition
+
+	for _, zname := range zexprNames(p.Source.Expression) {
+		ref := &Reference{Source: zname, Relation: d.Relation(zname.Name), Permission: d.Permission(zname.Name)}
+		if !ref.Resolved() {
+			c.report(CodeUnknownName, SeverityError, zname.Span, []*Definition{d}, "%s used by permission %s does not exist in definition %s", zname.Name, p.Name(), d.Name())
+			c.suggest(d.SuggestMembers(zname.Name))
+		}
+		p.References = append(p.References, ref)
+	}
+
+	for _, zarrow := range zexprArrows(p.Source.Expression) {
+		arrow := &Arrow{Source: zarrow, Relation: d.Relation(zarrow.Relation)}
+		p.Arrows = append(p.Arrows, arrow)
+		if arrow.Relation == nil {
+			c.report(CodeUnknownArrow, SeverityError, zarrow.Span, []*Definition{d}, "%s used by permission %s is not a relation of definition %s", zarrow.Relation, p.Name(), d.Name())
+			c.suggest(d.SuggestRelations(zarrow.Relation))
+			continue
+		}
+
+		checked := make(map[*Definition]bool)
+		for _, s := range arrow.Relation.Subjects {
+			// as SpiceDB, an arrow does not walk the wildcards of its relation
+			if s.Kind == WildcardSubject {
+				c.report(CodeWildcardArrow, SeverityError, zarrow.Span, []*Definition{d}, "%s used by permission %s of definition %s has the wildcard %s, an arrow can not walk it", zarrow.Relation, p.Name(), d.Name(), s.Label())
+				continue
+			}
+			// a missing definition is already reported on the relation
+			if s.Target == nil || checked[s.Target] {
+				continue
+			}
+			checked[s.Target] = true
+			if s.Target.Relation(zarrow.Target) == nil && s.Target.Permission(zarrow.Target) == nil {
+				arrow.MissingIn = append(arrow.MissingIn, s.Target)
+				c.report(CodeMissingArrowTarget, SeverityWarning, zarrow.Span, []*Definition{d, s.Target}, "%s used by permission %s of definition %s does not exist in %s", zarrow.Target, p.Name(), d.Name(), s.Target.Name())
+				c.suggest(s.Target.SuggestMembers(zarrow.Target))
+			}
+		}
+	}
+}
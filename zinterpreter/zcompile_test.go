@@ -0,0 +1,151 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func compileInput(t *testing.T, input string) (*Schema, []Diagnostic) {
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+	return Compile(z, lexer.ZCaveats())
+}
+
+func TestCompile(t *testing.T) {
+	schema, diagnostics := compileInput(t, `definition user {}
+definition group { relation member: user | group#member }
+definition document {
+	relation viewer: user | group#member | user:*
+	permission view = viewer
+}`)
+	if len(diagnostics) != 0 {
+		t.Fatalf("did not expect a diagnostic, got %v", diagnostics)
+	}
+
+	user, group, document := schema.Definition("user"), schema.Definition("group"), schema.Definition("document")
+	if user == nil || group == nil || document == nil || len(schema.Definitions) != 3 {
+		t.Fatalf("expected user, group and document")
+	}
+	viewer := document.Relation("viewer")
+	if viewer.Definition != document || len(viewer.Subjects) != 3 {
+		t.Fatalf("expected viewer with 3 subject types in document")
+	}
+	if s := viewer.Subjects[0]; s.Kind != ObjectSubject || s.Target != user || !s.Resolved() {
+		t.Errorf("expected user, got %s", s.Label())
+	}
+	if s := viewer.Subjects[1]; s.Kind != SubjectSet || s.Target != group || s.TargetRelation != group.Relation("member") || s.Label() != "group#member" {
+		t.Errorf("expected group#member, got %s", s.Label())
+	}
+	if s := viewer.Subjects[2]; s.Kind != WildcardSubject || s.Target != user || s.Label() != "user:*" {
+		t.Errorf("expected user:*, got %s", s.Label())
+	}
+	if ref := document.Permission("view").References[0]; ref.Relation != viewer {
+		t.Errorf("expected view to reference viewer")
+	}
+}
+
+func TestCompileDiagnostics(t *testing.T) {
+	schema, diagnostics := compileInput(t, `caveat c(a int) { a > 1 }
+caveat c(b int) { b > 1 }
+definition user {}
+definition user {}
+definition document {
+	relation viewer: user | user | group | user#owner
+	relation viewer: user
+	permission viewer = nil
+}`)
+	expected := []string{
+		"2:1: caveat c is declared more than once",
+		"4:1: definition user is declared more than once",
+		"7:2: relation viewer is declared more than once in definition document",
+		"8:2: permission viewer is declared more than once in definition document",
+		"6:26: user is declared more than once in relation viewer of definition document",
+		"6:33: definition group used in relation viewer of definition document does not exist",
+		"6:41: relation owner used in relation viewer of definition document does not exist in user",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d:\n%v", len(expected), len(diagnostics), diagnostics)
+	}
+	for i, diagnostic := range diagnostics {
+		if got := diagnostic.Span.String() + ": " + diagnostic.Message; got != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got)
+		}
+	}
+	codes := []string{CodeDuplicateCaveat, CodeDuplicateDefinition, CodeDuplicateRelation, CodeDuplicatePermission, CodeDuplicateSubject, CodeUnknownDefinition, CodeUnknownRelation}
+	for i, code := range codes {
+		if diagnostics[i].Code != code {
+			t.Errorf("expected code %s, got %s", code, diagnostics[i].Code)
+		}
+	}
+	if diagnostics[4].Severity != SeverityWarning || diagnostics[5].Severity != SeverityError {
+		t.Errorf("expected a duplicate subject to be a warning and an unknown definition to be an error")
+	}
+	if related := diagnostics[6].Related; len(related) != 2 || related[0] != "document" || related[1] != "user" {
+		t.Errorf("expected document and user to be related to user#owner, got %v", related)
+	}
+
+	// the names used in the schema refer to the first declaration
+	if schema.Definition("user") != schema.Definitions[0] || !schema.Definitions[1].Duplicate {
+		t.Errorf("expected the second user to be a duplicate")
+	}
+	if schema.Caveat("c") != schema.Caveats[0] || !schema.Caveats[1].Duplicate {
+		t.Errorf("expected the second caveat c to be a duplicate")
+	}
+	document := schema.Definition("document")
+	if document.Relation("viewer") != document.Relations[0] || !document.Relations[1].Duplicate || !document.Permissions[0].Duplicate {
+		t.Errorf("expected the second viewer and the permission viewer to be duplicates")
+	}
+}
+
+func TestCompileWildcardArrow(t *testing.T) {
+	_, diagnostics := compileInput(t, `definition user {}
+definition folder { relation viewer: user }
+definition document {
+	relation parent: folder
+	relation public: folder:*
+	relation shared: folder | folder:*
+	permission view = parent->viewer + public->viewer + shared->viewer
+}`)
+	expected := []string{
+		"7:37: public used by permission view of definition document has the wildcard folder:*, an arrow can not walk it",
+		"7:54: shared used by permission view of definition document has the wildcard folder:*, an arrow can not walk it",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d:\n%v", len(expected), len(diagnostics), diagnostics)
+	}
+	for i, diagnostic := range diagnostics {
+		if got := diagnostic.Span.String() + ": " + diagnostic.Message; got != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got)
+		}
+		if diagnostic.Code != CodeWildcardArrow || diagnostic.Severity != SeverityError {
+			t.Errorf("expected a %s error, got %s", CodeWildcardArrow, diagnostic)
+		}
+	}
+}
+
+func TestGenerateFromSchema(t *testing.T) {
+	lexer := NewLexer(`definition user {} definition user {} definition doc { relation viewer: user | user relation viewer: user }`)
+	lexer.NextToken()
+	z, _ := lexer.ReadZSchema()
+
+	mydraw := PlantUMLArchimateSchema{Zdefs: z}
+	out := mydraw.Generate("doc")
+
+	if strings.Contains(out, "NOTDRAW") || strings.Contains(out, "Business_Object(,") {
+		t.Errorf("unexpected element without variable:\n%s", out)
+	}
+	for _, line := range []string{
+		"rectangle \"definition user is declared more than one \" #red",
+		"Business_Object(r_doc_viewer,\"viewer\") <<relation>>",
+		"Rel_Access_w(r_doc_viewer,d_user)",
+		"rectangle \"relation viewer is duplicated in definition doc \" #red",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in:\n%s", line, out)
+		}
+	}
+}
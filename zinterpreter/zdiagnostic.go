@@ -0,0 +1,240 @@
+package zinterpreter
+
+// Diagnostics
+//
+// a Diagnostic is a syntax error or a semantic problem of a schema
+// with a stable code, so that a CI can filter them,
+// and they can be written as text, as JSON or as SARIF to annotate a pull request
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "?"
+	}
+}
+
+// diagnostic codes
+const (
+	CodeSyntax              = "syntax"
+	CodeDuplicateDefinition = "duplicate-definition"
+	CodeDuplicateRelation   = "duplicate-relation"
+	CodeDuplicatePermission = "duplicate-permission"
+	CodeDuplicateCaveat     = "duplicate-caveat"
+	CodeDuplicateSubject    = "duplicate-subject"
+	CodeUnknownDefinition   = "unknown-definition"
+	CodeUnknownRelation     = "unknown-relation"
+	CodeUnknownCaveat       = "unknown-caveat"
+	CodeUnknownName         = "unknown-name"
+	CodeUnknownArrow        = "unknown-arrow"
+	CodeMissingArrowTarget  = "missing-arrow-target"
+	CodeWildcardArrow       = "wildcard-arrow"
+)
+
+// Related are the full names of the definitions involved in the problem
+// Suggestions are the existing names close to an unknown name, they are also in Message
+type Diagnostic struct {
+	Code        string
+	Severity    Severity
+	Message     string
+	Span        Span
+	Related     []string
+	Suggestions []string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s [%s]", d.Span, d.Severity, d.Message, d.Code)
+}
+
+// SyntaxDiagnostics returns the errors of ReadZSchema as diagnostics
+func SyntaxDiagnostics(err error) []Diagnostic {
+	var diagnostics []Diagnostic
+	switch e := err.(type) {
+	case nil:
+	case ErrorList:
+		for _, syntaxError := range e {
+			diagnostics = append(diagnostics, syntaxError.diagnostic())
+		}
+	case *SyntaxError:
+		diagnostics = append(diagnostics, e.diagnostic())
+	default:
+		diagnostics = append(diagnostics, Diagnostic{Code: CodeSyntax, Severity: SeverityError, Message: e.Error()})
+	}
+	return diagnostics
+}
+
+// a syntax error without its end is the span of its position, so that every span has an end
+func (e *SyntaxError) diagnostic() Diagnostic {
+	end := e.End
+	if end.Line == 0 {
+		end = e.Pos
+	}
+	return Diagnostic{Code: CodeSyntax, Severity: SeverityError, Message: e.Message, Span: Span{Start: e.Pos, End: end}}
+}
+
+// HasErrors is true when a diagnostic is an error
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteDiagnosticsText writes one diagnostic per line
+func WriteDiagnosticsText(w io.Writer, diagnostics []Diagnostic) error {
+	for _, d := range diagnostics {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonDiagnostic struct {
+	Code        string   `json:"code"`
+	Severity    string   `json:"severity"`
+	Message     string   `json:"message"`
+	File        string   `json:"file,omitempty"`
+	Line        int      `json:"line"`
+	Column      int      `json:"column"`
+	EndLine     int      `json:"endLine"`
+	EndColumn   int      `json:"endColumn"`
+	Related     []string `json:"related,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// WriteDiagnosticsJSON writes the diagnostics as a JSON array
+func WriteDiagnosticsJSON(w io.Writer, diagnostics []Diagnostic) error {
+	out := []jsonDiagnostic{}
+	for _, d := range diagnostics {
+		out = append(out, jsonDiagnostic{
+			Code:        d.Code,
+			Severity:    d.Severity.String(),
+			Message:     d.Message,
+			File:        d.Span.Start.File,
+			Line:        d.Span.Start.Line,
+			Column:      d.Span.Start.Col,
+			EndLine:     d.Span.End.Line,
+			EndColumn:   d.Span.End.Col,
+			Related:     d.Related,
+			Suggestions: d.Suggestions,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// SARIF 2.1.0, the format of the code scanning annotations
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+// WriteDiagnosticsSARIF writes the diagnostics as a SARIF log
+// a diagnostic of a schema without file name has no location
+func WriteDiagnosticsSARIF(w io.Writer, diagnostics []Diagnostic) error {
+	run := sarifRun{
+		Tool:       sarifTool{Driver: sarifDriver{Name: "zreader", Rules: []sarifRule{}}},
+		ColumnKind: "unicodeCodePoints",
+		Results:    []sarifResult{},
+	}
+	rules := make(map[string]bool)
+	for _, d := range diagnostics {
+		if !rules[d.Code] {
+			rules[d.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Code})
+		}
+		result := sarifResult{RuleID: d.Code, Level: d.Severity.String(), Message: sarifMessage{Text: d.Message}}
+		if d.Span.Start.File != "" {
+			location := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: d.Span.Start.File}}
+			if d.Span.Start.Line > 0 {
+				location.Region = &sarifRegion{StartLine: d.Span.Start.Line, StartColumn: d.Span.Start.Col}
+				if d.Span.End.Line > 0 {
+					location.Region.EndLine = d.Span.End.Line
+					location.Region.EndColumn = d.Span.End.Col
+				}
+			}
+			result.Locations = []sarifLocation{{PhysicalLocation: location}}
+		}
+		run.Results = append(run.Results, result)
+	}
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
@@ -0,0 +1,88 @@
+package zinterpreter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxDiagnostics(t *testing.T) {
+	lexer := NewFileLexer("a.zed", "definition doc { relation : user }\ndefinition é {}")
+	lexer.NextToken()
+	_, err := lexer.ReadZSchema()
+
+	diagnostics := SyntaxDiagnostics(err)
+	if len(diagnostics) != 2 || !HasErrors(diagnostics) {
+		t.Fatalf("expected 2 syntax errors, got %v", diagnostics)
+	}
+	if d := diagnostics[0]; d.Code != CodeSyntax || d.Span.Start.File != "a.zed" || d.Span.Start.Line != 1 || d.Span.Start.Col != 27 {
+		t.Errorf("unexpected diagnostic %s", d)
+	}
+	// the span ends after the offending token
+	if end := diagnostics[0].Span.End; end.File != "a.zed" || end.Line != 1 || end.Col != 28 {
+		t.Errorf("expected the end 1:28, got %s", end)
+	}
+	for _, d := range diagnostics {
+		if d.Span.End.Line == 0 {
+			t.Errorf("expected an end, got %s", d)
+		}
+	}
+	pos := Position{File: "a.zed", Line: 2, Col: 3}
+	if d := (&SyntaxError{Pos: pos, Message: "unexpected"}).diagnostic(); d.Span.End != pos {
+		t.Errorf("expected the end %s without the end of the token, got %s", pos, d.Span.End)
+	}
+	if SyntaxDiagnostics(nil) != nil {
+		t.Errorf("expected no diagnostic without error")
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Code: CodeUnknownDefinition, Severity: SeverityError, Message: "definition group used in relation viewer of definition doc does not exist",
+			Span: Span{Start: Position{File: "a.zed", Line: 2, Col: 19}, End: Position{File: "a.zed", Line: 2, Col: 24}}, Related: []string{"doc"}},
+		{Code: CodeDuplicateSubject, Severity: SeverityWarning, Message: "user is declared more than once",
+			Span: Span{Start: Position{Line: 3, Col: 5}}},
+	}
+
+	var text bytes.Buffer
+	WriteDiagnosticsText(&text, diagnostics)
+	expected := "a.zed:2:19: error: definition group used in relation viewer of definition doc does not exist [unknown-definition]\n" +
+		"3:5: warning: user is declared more than once [duplicate-subject]\n"
+	if text.String() != expected {
+		t.Errorf("unexpected text:\n%s", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := WriteDiagnosticsJSON(&out, diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	if len(decoded) != 2 || decoded[0]["code"] != "unknown-definition" || decoded[0]["endColumn"] != 24.0 || decoded[1]["severity"] != "warning" {
+		t.Errorf("unexpected JSON:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := WriteDiagnosticsSARIF(&out, diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("invalid SARIF: %v\n%s", err, out.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 || len(log.Runs[0].Tool.Driver.Rules) != 2 {
+		t.Fatalf("unexpected SARIF:\n%s", out.String())
+	}
+	result := log.Runs[0].Results[0]
+	if result.RuleID != "unknown-definition" || result.Level != "error" || result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "a.zed" ||
+		result.Locations[0].PhysicalLocation.Region.StartLine != 2 || result.Locations[0].PhysicalLocation.Region.EndColumn != 24 {
+		t.Errorf("unexpected SARIF result:\n%s", out.String())
+	}
+	// an inline schema has no location
+	if len(log.Runs[0].Results[1].Locations) != 0 || !strings.Contains(out.String(), `"level": "warning"`) {
+		t.Errorf("unexpected SARIF result:\n%s", out.String())
+	}
+}
@@ -0,0 +1,159 @@
+package zinterpreter
+
+// Semantic comparison of two schemas
+//
+// the definitions, relations, permissions and caveats are compared by name
+// a relation is compared on its subject types in the canonical order of Format,
+// a permission on its expression and a caveat on its signature and its expression,
+// so that the comments and the layout of the schemas are ignored
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+// Change is a difference between two schemas
+// Element is "definition", "relation", "permission" or "caveat"
+// Name is definition, definition#member or caveat
+// Old and New are the compared texts, empty when the element is added or removed
+type Change struct {
+	Kind    ChangeKind
+	Element string
+	Name    string
+	Old     string
+	New     string
+	Span    Span // in the new schema, or in the old schema when the element is removed
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s %s: %s", c.Element, c.Name, c.New)
+	case Removed:
+		return fmt.Sprintf("- %s %s: %s", c.Element, c.Name, c.Old)
+	default:
+		return fmt.Sprintf("~ %s %s: %s => %s", c.Element, c.Name, c.Old, c.New)
+	}
+}
+
+// DiffSchemas returns the changes from old to new
+// the removed and changed elements come in the order of old, then the added ones in the order of new
+// the duplicated elements are ignored, as they are reported by Compile
+func DiffSchemas(old *Schema, new *Schema) []Change {
+	var changes []Change
+
+	for _, caveat := range old.Caveats {
+		if caveat.Duplicate {
+			continue
+		}
+		if other := new.Caveat(caveat.Name()); other == nil {
+			changes = append(changes, Change{Removed, "caveat", caveat.Name(), caveatText(caveat), "", caveat.Source.Span})
+		} else if caveatText(caveat) != caveatText(other) {
+			changes = append(changes, Change{Changed, "caveat", caveat.Name(), caveatText(caveat), caveatText(other), other.Source.Span})
+		}
+	}
+	for _, caveat := range new.Caveats {
+		if !caveat.Duplicate && old.Caveat(caveat.Name()) == nil {
+			changes = append(changes, Change{Added, "caveat", caveat.Name(), "", caveatText(caveat), caveat.Source.Span})
+		}
+	}
+
+	for _, d := range old.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		other := new.Definition(d.Name())
+		if other == nil {
+			changes = append(changes, Change{Removed, "definition", d.Name(), definitionText(d), "", d.Source.Span})
+			continue
+		}
+		changes = append(changes, diffDefinitions(d, other)...)
+	}
+	for _, d := range new.Definitions {
+		if !d.Duplicate && old.Definition(d.Name()) == nil {
+			changes = append(changes, Change{Added, "definition", d.Name(), "", definitionText(d), d.Source.Span})
+		}
+	}
+	return changes
+}
+
+func diffDefinitions(old *Definition, new *Definition) []Change {
+	var changes []Change
+	name := func(member string) string {
+		return old.Name() + "#" + member
+	}
+
+	for _, r := range old.Relations {
+		if r.Duplicate {
+			continue
+		}
+		if other := new.Relation(r.Name()); other == nil {
+			changes = append(changes, Change{Removed, "relation", name(r.Name()), relationText(r), "", r.Source.Span})
+		} else if relationText(r) != relationText(other) {
+			changes = append(changes, Change{Changed, "relation", name(r.Name()), relationText(r), relationText(other), other.Source.Span})
+		}
+	}
+	for _, r := range new.Relations {
+		if !r.Duplicate && old.Relation(r.Name()) == nil {
+			changes = append(changes, Change{Added, "relation", name(r.Name()), "", relationText(r), r.Source.Span})
+		}
+	}
+
+	for _, p := range old.Permissions {
+		if p.Duplicate {
+			continue
+		}
+		if other := new.Permission(p.Name()); other == nil {
+			changes = append(changes, Change{Removed, "permission", name(p.Name()), p.Source.Expression.String(), "", p.Source.Span})
+		} else if zexprCanonical(p.Source.Expression) != zexprCanonical(other.Source.Expression) {
+			changes = append(changes, Change{Changed, "permission", name(p.Name()), p.Source.Expression.String(), other.Source.Expression.String(), other.Source.Span})
+		}
+	}
+	for _, p := range new.Permissions {
+		if !p.Duplicate && old.Permission(p.Name()) == nil {
+			changes = append(changes, Change{Added, "permission", name(p.Name()), "", p.Source.Expression.String(), p.Source.Span})
+		}
+	}
+	return changes
+}
+
+// the subject types, as written by Format
+func relationText(r *Relation) string {
+	return strings.TrimPrefix(formatZRelation(r.Source), "relation "+r.Name()+": ")
+}
+
+// the members of a definition
+func definitionText(d *Definition) string {
+	members := []string{}
+	for _, r := range d.Relations {
+		members = append(members, r.Name())
+	}
+	for _, p := range d.Permissions {
+		members = append(members, p.Name())
+	}
+	return "{" + strings.Join(members, ", ") + "}"
+}
+
+// the signature and the expression on one line
+func caveatText(c *Caveat) string {
+	return c.Source.Signature() + " { " + strings.Join(strings.Fields(c.Source.Expression), " ") + " }"
+}
@@ -0,0 +1,53 @@
+package zinterpreter
+
+import (
+	"testing"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	old, _ := compileInput(t, `caveat weekday(day int) { day < 6 }
+caveat ip(addr string) { addr == "a" }
+definition user {}
+definition team {}
+definition document {
+	relation viewer: user | team#member
+	relation owner: user
+	permission view = viewer + owner
+	permission edit = owner
+}`)
+	new, _ := compileInput(t, `caveat weekday(day int) {
+	day <    6
+}
+definition user {}
+definition group { relation member: user }
+// a comment does not change the schema
+definition document {
+	relation owner: user
+	relation viewer: team#member | user | group#member
+	permission view = (viewer + owner)
+	permission edit = owner - viewer
+	permission admin = owner
+}`)
+
+	expected := []string{
+		"- caveat ip: ip(addr string) { addr == \"a\" }",
+		"- definition team: {}",
+		"~ relation document#viewer: user | team#member => user | team#member | group#member",
+		"~ permission document#edit: owner => owner - viewer",
+		"+ permission document#admin: owner",
+		"+ definition group: {member}",
+	}
+	changes := DiffSchemas(old, new)
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %v", len(expected), changes)
+	}
+	for i, change := range changes {
+		if change.String() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], change.String())
+		}
+	}
+
+	if changes := DiffSchemas(new, new); len(changes) != 0 {
+		t.Errorf("expected no change, got %v", changes)
+	}
+}
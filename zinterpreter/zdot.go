@@ -0,0 +1,251 @@
+package zinterpreter
+
+// Graphviz DOT generation
+//
+// a definition is a record node, its relations and permissions are the fields of the record
+// the definitions with a prefix are in a cluster named after the prefix
+// a subject type is an edge from the field of the relation to its definition,
+// a subject set goes to the field of the relation of the set, a wildcard is a dashed edge labeled *
+// the nodes are named after the definitions, so that two renders of close schemas diff cleanly
+// the errors are red nodes named after their message
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DotSchema struct {
+	Zdefs    []*ZDef
+	Zcaveats []*ZCaveat
+	Schema   *Schema // compiled from Zdefs and Zcaveats when nil
+
+	HideDocs bool // do not write the Doc comments as tooltips
+
+	Options RenderOptions // direction, title, dpi and colors
+
+	out    []string
+	errors map[string]bool // the error nodes already written
+}
+
+type dotRenderer struct{}
+
+func init() {
+	RegisterRenderer(dotRenderer{})
+}
+
+func (dotRenderer) Name() string {
+	return "dot"
+}
+
+func (dotRenderer) Extension() string {
+	return ".dot"
+}
+
+func (dotRenderer) Render(schema *Schema, options RenderOptions) string {
+	mydraw := DotSchema{Schema: options.schema(schema), HideDocs: options.HideDocs, Options: options}
+	return mydraw.Generate(options.Name)
+}
+
+// Generate returns the digraph named title
+func (dotSchema *DotSchema) Generate(title string) string {
+	if dotSchema.Schema == nil {
+		dotSchema.Schema, _ = Compile(dotSchema.Zdefs, dotSchema.Zcaveats)
+	}
+	schema := dotSchema.Schema
+	dotSchema.out = nil
+	dotSchema.errors = make(map[string]bool)
+	options := dotSchema.Options
+	add := dotSchema.add
+
+	dotSchema.out = append(dotSchema.out, fmt.Sprintf("digraph %s {", dotID(title)))
+	direction := "LR"
+	if options.Direction != "" {
+		direction = options.Direction
+	}
+	add("rankdir=%s;", direction)
+	if options.Dpi != 0 {
+		add("dpi=%d;", options.Dpi)
+	}
+	if options.Title != "" {
+		add("label=%s;", dotID(options.Title))
+		add("labelloc=t;")
+	}
+	add("node [shape=record, style=filled, fillcolor=\"#ffffb5\", fontname=\"Helvetica\"];")
+	add("edge [fontname=\"Helvetica\", fontsize=10];")
+
+	// definitions, with a prefix in a cluster
+
+	prefixes := []string{}
+	for _, d := range schema.Definitions {
+		switch {
+		case d.Duplicate:
+			dotSchema.addError("definition %s is declared more than once", d.Name())
+		case d.Source.Prefix == "":
+			dotSchema.add(dotSchema.definitionNode(d))
+		case !contains(prefixes, d.Source.Prefix):
+			prefixes = append(prefixes, d.Source.Prefix)
+		}
+	}
+	for _, prefix := range prefixes {
+		add("subgraph %s {", dotID("cluster_"+prefix))
+		add("\tlabel=%s;", dotID(prefix))
+		for _, d := range schema.Definitions {
+			if d.Source.Prefix == prefix && !d.Duplicate {
+				add("\t" + dotSchema.definitionNode(d))
+			}
+		}
+		add("}")
+	}
+
+	for _, caveat := range schema.Caveats {
+		if caveat.Duplicate {
+			dotSchema.addError("caveat %s is declared more than once", caveat.Name())
+			continue
+		}
+		add("%s [shape=hexagon, fillcolor=\"#ccccff\", label=%s%s];", dotID("caveat "+caveat.Name()), dotID(caveat.Source.Signature()), dotSchema.tooltip(caveat.Source.Doc))
+	}
+
+	// the members of a duplicated definition are not drawn
+	for _, d := range schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				dotSchema.addError("relation %s is declared more than once in definition %s", r.Name(), d.Name())
+				continue
+			}
+			from := dotPort(d, r.Name())
+			drawn := []string{}
+			for _, s := range orderedSubjects(r) {
+				switch {
+				case s.Target == nil:
+					dotSchema.addErrorEdge(from, "definition %s does not exist%s", s.Name, DidYouMean(schema.SuggestDefinitions(s.Name, d)))
+				case s.Kind == SubjectSet && s.TargetRelation == nil:
+					dotSchema.addErrorEdge(from, "%s in definition %s : relation %s does not exist in %s%s", s.Label(), d.Name(), s.RelationName, s.Name, DidYouMean(s.Target.SuggestRelations(s.RelationName)))
+				case s.Duplicate:
+					dotSchema.addErrorEdge(from, "%s is declared more than once in relation %s of definition %s", s.Label(), r.Name(), d.Name())
+				case s.Kind == SubjectSet:
+					add("%s -> %s [label=%s];", from, dotPort(s.Target, s.RelationName), dotID(withCaveat("#"+s.RelationName, s.CaveatName)))
+				case s.Kind == WildcardSubject:
+					add("%s -> %s [label=%s, style=dashed%s];", from, dotID(s.Target.Name()), dotID(withCaveat("*", s.CaveatName)), dotColor(options.WildcardColor))
+				default:
+					add("%s -> %s [label=%s];", from, dotID(s.Target.Name()), dotID(withCaveat(s.Name, s.CaveatName)))
+				}
+				switch {
+				case s.CaveatName == "":
+					// no caveat
+				case s.Caveat == nil:
+					dotSchema.addErrorEdge(from, "caveat %s used in relation %s of definition %s does not exist%s", s.CaveatName, r.Name(), d.Name(), DidYouMean(schema.SuggestCaveats(s.CaveatName)))
+				case !contains(drawn, s.CaveatName):
+					// the caveat is drawn once for the relation
+					drawn = append(drawn, s.CaveatName)
+					add("%s -> %s [label=\"with\", style=dotted, arrowhead=none];", from, dotID("caveat "+s.CaveatName))
+				}
+			}
+		}
+		for _, p := range d.Permissions {
+			if p.Duplicate {
+				dotSchema.addError("permission %s is declared more than once in definition %s", p.Name(), d.Name())
+				continue
+			}
+			from := dotPort(d, p.Name())
+			for _, ref := range p.References {
+				if !ref.Resolved() {
+					dotSchema.addErrorEdge(from, "%s used by permission %s does not exist in definition %s%s", ref.Source.Name, p.Name(), d.Name(), DidYouMean(d.SuggestMembers(ref.Source.Name)))
+				}
+			}
+			for _, arrow := range p.Arrows {
+				if arrow.Relation == nil {
+					dotSchema.addErrorEdge(from, "%s used by permission %s is not a relation of definition %s%s", arrow.Source.Relation, p.Name(), d.Name(), DidYouMean(d.SuggestRelations(arrow.Source.Relation)))
+					continue
+				}
+				for _, missing := range arrow.MissingIn {
+					dotSchema.addErrorEdge(from, "%s used by permission %s of definition %s does not exist in %s%s", arrow.Source.Target, p.Name(), d.Name(), missing.Name(), DidYouMean(missing.SuggestMembers(arrow.Source.Target)))
+				}
+			}
+		}
+	}
+
+	dotSchema.out = append(dotSchema.out, "}")
+	return strings.Join(dotSchema.out, "\n") + "\n"
+}
+
+func (dotSchema *DotSchema) add(format string, args ...interface{}) {
+	dotSchema.out = append(dotSchema.out, "\t"+fmt.Sprintf(format, args...))
+}
+
+// {name|<viewer> viewer|<view> view = viewer + owner}
+func (dotSchema *DotSchema) definitionNode(d *Definition) string {
+	fields := []string{dotRecordText(d.Source.Name)}
+	for _, r := range d.Relations {
+		if !r.Duplicate {
+			fields = append(fields, fmt.Sprintf("<%s> %s", r.Name(), dotRecordText(r.Name())))
+		}
+	}
+	for _, p := range d.Permissions {
+		if !p.Duplicate {
+			fields = append(fields, fmt.Sprintf("<%s> %s", p.Name(), dotRecordText(p.Name()+" = "+p.Source.Expression.String())))
+		}
+	}
+	return fmt.Sprintf("%s [label=%s%s];", dotID(d.Name()), dotID("{"+strings.Join(fields, "|")+"}"), dotSchema.tooltip(d.Source.Doc))
+}
+
+func (dotSchema *DotSchema) tooltip(doc string) string {
+	text := CommentText(doc)
+	if dotSchema.HideDocs || text == "" {
+		return ""
+	}
+	return ", tooltip=" + dotID(text)
+}
+
+// an error is a red node named after its message, written once
+func (dotSchema *DotSchema) addError(format string, args ...interface{}) string {
+	message := fmt.Sprintf(format, args...)
+	id := dotID("error " + message)
+	if dotSchema.errors[id] {
+		return id
+	}
+	dotSchema.errors[id] = true
+	dotSchema.add("%s [shape=box, style=filled, fillcolor=%s, fontcolor=white, label=%s];", id, dotColorValue(dotSchema.Options.errorColor("red")), dotID(message))
+	return id
+}
+
+// the error of a relation or a permission is linked to its field
+func (dotSchema *DotSchema) addErrorEdge(from string, format string, args ...interface{}) {
+	id := dotSchema.addError(format, args...)
+	dotSchema.add("%s -> %s [color=%s];", from, id, dotColorValue(dotSchema.Options.errorColor("red")))
+}
+
+// dotColor is the color attribute of an edge, nothing without color
+func dotColor(color string) string {
+	if color == "" {
+		return ""
+	}
+	return ", color=" + dotColorValue(color)
+}
+
+// a color name is written as is, #rrggbb is quoted
+func dotColorValue(color string) string {
+	if strings.HasPrefix(color, "#") {
+		return dotID(color)
+	}
+	return color
+}
+
+func dotPort(d *Definition, member string) string {
+	return dotID(d.Name()) + ":" + dotID(member)
+}
+
+// dotID quotes an identifier or a label
+// the backslashes are kept, they escape the characters of the records
+func dotID(text string) string {
+	replacer := strings.NewReplacer("\"", "\\\"", "\n", "\\n")
+	return "\"" + replacer.Replace(text) + "\""
+}
+
+// dotRecordText escapes the characters of the record labels
+func dotRecordText(text string) string {
+	replacer := strings.NewReplacer("{", "\\{", "}", "\\}", "|", "\\|", "<", "\\<", ">", "\\>")
+	return replacer.Replace(text)
+}
@@ -0,0 +1,59 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDot(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {}
+definition acme/group { relation member: user | user }
+definition acme/user {}
+caveat weekday(day int) { day < 6 }
+// documents
+definition document {
+	relation viewer: user with weekday | acme/group#member | user:* | usr
+	permission view = viewer + parent->view
+}`)
+
+	mydraw := DotSchema{Schema: schema}
+	out := mydraw.Generate("doc")
+	for _, line := range []string{
+		"digraph \"doc\" {",
+		"\t\"user\" [label=\"{user}\"];",
+		"\tsubgraph \"cluster_acme\" {",
+		"\t\t\"acme/group\" [label=\"{group|<member> member}\"];",
+		"\t\"document\" [label=\"{document|<viewer> viewer|<view> view = viewer + parent-\\>view}\", tooltip=\"documents\"];",
+		"\t\"caveat weekday\" [shape=hexagon",
+		"\t\"document\":\"viewer\" -> \"user\" [label=\"user with weekday\"];",
+		"\t\"document\":\"viewer\" -> \"caveat weekday\" [label=\"with\", style=dotted, arrowhead=none];",
+		"\t\"acme/group\":\"member\" -> \"acme/user\" [label=\"user\"];",
+		"\t\"document\":\"viewer\" -> \"acme/group\":\"member\" [label=\"#member\"];",
+		"\t\"document\":\"viewer\" -> \"user\" [label=\"*\", style=dashed];",
+		"\t\"document\":\"viewer\" -> \"error definition usr does not exist, did you mean user?\" [color=red];",
+		"\t\"acme/group\":\"member\" -> \"error user is declared more than once in relation member of definition acme/group\" [color=red];",
+		"\t\"document\":\"view\" -> \"error parent used by permission view is not a relation of definition document\" [color=red];",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in:\n%s", line, out)
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, " -> ") && !strings.Contains(line, "label=") && !strings.Contains(line, "color=red") {
+			t.Errorf("expected a label on %s", line)
+		}
+	}
+}
+
+// the nodes are named after the definitions : a new definition does not change the other lines
+func TestDotStableIDs(t *testing.T) {
+	before, _ := compileInput(t, `definition user {} definition document { relation viewer: user }`)
+	after, _ := compileInput(t, `definition team {} definition user {} definition document { relation viewer: user | team }`)
+	beforeDot := (&DotSchema{Schema: before}).Generate("doc")
+	afterDot := (&DotSchema{Schema: after}).Generate("doc")
+	for _, line := range strings.Split(beforeDot, "\n") {
+		if !strings.Contains(afterDot, line) {
+			t.Errorf("expected %s to be kept in:\n%s", line, afterDot)
+		}
+	}
+}
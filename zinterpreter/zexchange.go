@@ -0,0 +1,401 @@
+package zinterpreter
+
+// ArchiMate Open Exchange Format generation
+//
+// the model can be imported in Archi and the other tools reading the Open Group exchange format :
+// a definition, a relation and a permission are Business Objects, a caveat is a Constraint,
+// a prefix is a Grouping aggregating its definitions
+// a definition is composed of its relations and permissions,
+// a subject type is a directed Association from the relation to its definition, labeled with the wildcard or the caveat,
+// a subject set is a directed Association to the relation of the set,
+// a relation using a caveat is associated with it,
+// a permission aggregates the relations and permissions it uses, as Rel_Aggregation in the PlantUML diagram
+// a generated view shows one column per definition, and the errors as red notes
+// only relationships allowed by ArchiMate between these elements are used, so that the import does not fail
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+type ArchimateExchangeSchema struct {
+	Zdefs    []*ZDef
+	Zcaveats []*ZCaveat
+	Schema   *Schema // compiled from Zdefs and Zcaveats when nil
+
+	HideDocs bool // do not write the Doc comments as documentation
+
+	Options RenderOptions // title and error color
+
+	ids           map[interface{}]string
+	elements      []exchangeElement
+	relationships []exchangeRelationship
+	errors        []string
+}
+
+type exchangeElement struct {
+	id, kind, name, doc string
+}
+
+type exchangeRelationship struct {
+	id, kind, source, target, name string
+	directed                       bool
+}
+
+// a node of the view, with its children for a grouping, a note when label is set
+type exchangeNode struct {
+	elementID           string
+	label               string
+	x, y, width, height int
+	children            []exchangeNode
+}
+
+const (
+	exchangeColumn = 180
+	exchangeWidth  = 140
+	exchangeHeight = 55
+	exchangeRow    = 75
+)
+
+type archimateExchangeRenderer struct{}
+
+func init() {
+	RegisterRenderer(archimateExchangeRenderer{})
+}
+
+func (archimateExchangeRenderer) Name() string {
+	return "archimate"
+}
+
+func (archimateExchangeRenderer) Extension() string {
+	return ".xml"
+}
+
+func (archimateExchangeRenderer) Render(schema *Schema, options RenderOptions) string {
+	mydraw := ArchimateExchangeSchema{Schema: options.schema(schema), HideDocs: options.HideDocs, Options: options}
+	return mydraw.Generate(options.Name)
+}
+
+// Generate returns the XML of the model named title, or the title of Options
+func (exchange *ArchimateExchangeSchema) Generate(title string) string {
+	if exchange.Options.Title != "" {
+		title = exchange.Options.Title
+	}
+	if exchange.Schema == nil {
+		exchange.Schema, _ = Compile(exchange.Zdefs, exchange.Zcaveats)
+	}
+	schema := exchange.Schema
+	exchange.ids = schemaIDs(schema)
+	exchange.elements, exchange.relationships, exchange.errors = nil, nil, nil
+	id := exchange.id
+
+	// elements
+
+	prefixes := []string{}
+	definitions := []*Definition{}
+	for _, d := range schema.Definitions {
+		if d.Duplicate {
+			exchange.addError("definition %s is declared more than once", d.Name())
+			continue
+		}
+		definitions = append(definitions, d)
+		if d.Source.Prefix != "" && !contains(prefixes, d.Source.Prefix) {
+			prefixes = append(prefixes, d.Source.Prefix)
+		}
+		exchange.addElement(id(d), "BusinessObject", d.Name(), d.Source.Doc)
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				exchange.addError("relation %s is declared more than once in definition %s", r.Name(), d.Name())
+				continue
+			}
+			exchange.addElement(id(r), "BusinessObject", r.Name(), r.Source.Doc)
+			exchange.addRelationship("Composition", id(d), id(r), "", false)
+		}
+		for _, p := range d.Permissions {
+			if p.Duplicate {
+				exchange.addError("permission %s is declared more than once in definition %s", p.Name(), d.Name())
+				continue
+			}
+			exchange.addElement(id(p), "BusinessObject", p.Name()+" = "+p.Source.Expression.String(), p.Source.Doc)
+			exchange.addRelationship("Composition", id(d), id(p), "", false)
+		}
+	}
+	for _, prefix := range prefixes {
+		group := id(groupingKey(prefix))
+		exchange.addElement(group, "Grouping", prefix, "")
+		for _, d := range definitions {
+			if d.Source.Prefix == prefix {
+				exchange.addRelationship("Aggregation", group, id(d), "", false)
+			}
+		}
+	}
+	for _, caveat := range schema.Caveats {
+		if caveat.Duplicate {
+			exchange.addError("caveat %s is declared more than once", caveat.Name())
+			continue
+		}
+		exchange.addElement(id(caveat), "Constraint", caveat.Source.Signature(), caveat.Source.Doc)
+	}
+
+	// relationships
+
+	for _, d := range definitions {
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				continue
+			}
+			drawn := []string{}
+			for _, s := range orderedSubjects(r) {
+				switch {
+				case s.Target == nil:
+					exchange.addError("definition %s does not exist%s", s.Name, DidYouMean(schema.SuggestDefinitions(s.Name, d)))
+				case s.Kind == SubjectSet && s.TargetRelation == nil:
+					exchange.addError("%s in definition %s : relation %s does not exist in %s%s", s.Label(), d.Name(), s.RelationName, s.Name, DidYouMean(s.Target.SuggestRelations(s.RelationName)))
+				case s.Duplicate:
+					exchange.addError("%s is declared more than once in relation %s of definition %s", s.Label(), r.Name(), d.Name())
+				case s.Kind == SubjectSet:
+					exchange.addRelationship("Association", id(r), id(s.TargetRelation), withCaveat(s.Label(), s.CaveatName), true)
+				case s.Kind == WildcardSubject:
+					exchange.addRelationship("Association", id(r), id(s.Target), withCaveat("ALL", s.CaveatName), true)
+				default:
+					exchange.addRelationship("Association", id(r), id(s.Target), withCaveat("", s.CaveatName), true)
+				}
+				switch {
+				case s.CaveatName == "":
+					// no caveat
+				case s.Caveat == nil:
+					exchange.addError("caveat %s used in relation %s of definition %s does not exist%s", s.CaveatName, r.Name(), d.Name(), DidYouMean(schema.SuggestCaveats(s.CaveatName)))
+				case !contains(drawn, id(s.Caveat)):
+					drawn = append(drawn, id(s.Caveat))
+					exchange.addRelationship("Association", id(r), id(s.Caveat), "", false)
+				}
+			}
+		}
+		for _, p := range d.Permissions {
+			if p.Duplicate {
+				continue
+			}
+			// a name used twice by the expression is drawn once
+			drawn := []string{}
+			for _, ref := range p.References {
+				if contains(drawn, ref.Source.Name) {
+					continue
+				}
+				drawn = append(drawn, ref.Source.Name)
+				switch {
+				case ref.Relation != nil:
+					exchange.addRelationship("Aggregation", id(p), id(ref.Relation), "", false)
+				case ref.Permission != nil:
+					exchange.addRelationship("Aggregation", id(p), id(ref.Permission), "", false)
+				default:
+					exchange.addError("%s used by permission %s does not exist in definition %s%s", ref.Source.Name, p.Name(), d.Name(), DidYouMean(d.SuggestMembers(ref.Source.Name)))
+				}
+			}
+			for _, arrow := range p.Arrows {
+				if contains(drawn, arrow.Source.String()) {
+					continue
+				}
+				drawn = append(drawn, arrow.Source.String())
+				if arrow.Relation == nil {
+					exchange.addError("%s used by permission %s is not a relation of definition %s%s", arrow.Source.Relation, p.Name(), d.Name(), DidYouMean(d.SuggestRelations(arrow.Source.Relation)))
+					continue
+				}
+				exchange.addRelationship("Aggregation", id(p), id(arrow.Relation), arrow.Source.String(), false)
+				for _, missing := range arrow.MissingIn {
+					exchange.addError("%s used by permission %s of definition %s does not exist in %s%s", arrow.Source.Target, p.Name(), d.Name(), missing.Name(), DidYouMean(missing.SuggestMembers(arrow.Source.Target)))
+				}
+			}
+		}
+	}
+
+	// XML
+
+	var out []string
+	out = append(out, `<?xml version="1.0" encoding="UTF-8"?>`)
+	out = append(out, `<model xmlns="http://www.opengroup.org/xsd/archimate/3.0/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.opengroup.org/xsd/archimate/3.0/ http://www.opengroup.org/xsd/archimate/3.1/archimate3_Diagram.xsd" identifier="id-model">`)
+	out = append(out, fmt.Sprintf(`  <name xml:lang="en">%s</name>`, xmlText(title)))
+
+	out = append(out, "  <elements>")
+	for _, e := range exchange.elements {
+		out = append(out, fmt.Sprintf(`    <element identifier="id-%s" xsi:type="%s">`, e.id, e.kind))
+		out = append(out, fmt.Sprintf(`      <name xml:lang="en">%s</name>`, xmlText(e.name)))
+		if e.doc != "" {
+			out = append(out, fmt.Sprintf(`      <documentation xml:lang="en">%s</documentation>`, xmlText(e.doc)))
+		}
+		out = append(out, "    </element>")
+	}
+	out = append(out, "  </elements>")
+
+	if len(exchange.relationships) > 0 {
+		out = append(out, "  <relationships>")
+		for _, r := range exchange.relationships {
+			attributes := fmt.Sprintf(`identifier="id-%s" source="id-%s" target="id-%s" xsi:type="%s"`, r.id, r.source, r.target, r.kind)
+			if r.directed {
+				attributes += ` isDirected="true"`
+			}
+			if r.name == "" {
+				out = append(out, fmt.Sprintf("    <relationship %s/>", attributes))
+				continue
+			}
+			out = append(out, fmt.Sprintf("    <relationship %s>", attributes))
+			out = append(out, fmt.Sprintf(`      <name xml:lang="en">%s</name>`, xmlText(r.name)))
+			out = append(out, "    </relationship>")
+		}
+		out = append(out, "  </relationships>")
+	}
+
+	out = append(out, "  <views>")
+	out = append(out, "    <diagrams>")
+	out = append(out, `      <view identifier="id-view" xsi:type="Diagram">`)
+	out = append(out, fmt.Sprintf(`        <name xml:lang="en">%s</name>`, xmlText(title)))
+	for _, node := range exchange.layout(definitions, prefixes) {
+		out = exchange.appendNode(out, node, "        ")
+	}
+	for _, r := range exchange.relationships {
+		out = append(out, fmt.Sprintf(`        <connection identifier="v-%s" relationshipRef="id-%s" xsi:type="Relationship" source="v-%s" target="v-%s"/>`, r.id, r.id, r.source, r.target))
+	}
+	out = append(out, "      </view>")
+	out = append(out, "    </diagrams>")
+	out = append(out, "  </views>")
+	out = append(out, "</model>")
+	return strings.Join(out, "\n") + "\n"
+}
+
+// one column per definition with its relations and permissions below it, the definitions of a prefix in their grouping
+// then a row of caveats and a row of errors
+func (exchange *ArchimateExchangeSchema) layout(definitions []*Definition, prefixes []string) []exchangeNode {
+	column, bottom := 0, 0
+
+	// the members are not nested in the definition : they are drawn below it
+	place := func(d *Definition) []exchangeNode {
+		x, y := 20+column*exchangeColumn, 60
+		column++
+		nodes := []exchangeNode{{elementID: exchange.id(d), x: x, y: y, width: exchangeWidth, height: exchangeHeight}}
+		var members []interface{}
+		for _, r := range d.Relations {
+			if !r.Duplicate {
+				members = append(members, r)
+			}
+		}
+		for _, p := range d.Permissions {
+			if !p.Duplicate {
+				members = append(members, p)
+			}
+		}
+		for _, m := range members {
+			y += exchangeRow
+			nodes = append(nodes, exchangeNode{elementID: exchange.id(m), x: x, y: y, width: exchangeWidth, height: exchangeHeight})
+		}
+		if y+exchangeHeight > bottom {
+			bottom = y + exchangeHeight
+		}
+		return nodes
+	}
+
+	var nodes []exchangeNode
+	for _, d := range definitions {
+		if d.Source.Prefix == "" {
+			nodes = append(nodes, place(d)...)
+		}
+	}
+	var groups []exchangeNode
+	for _, prefix := range prefixes {
+		group := exchangeNode{elementID: exchange.id(groupingKey(prefix)), x: 10 + column*exchangeColumn, y: 20}
+		for _, d := range definitions {
+			if d.Source.Prefix == prefix {
+				group.children = append(group.children, place(d)...)
+			}
+		}
+		group.width = 10 + column*exchangeColumn - group.x
+		groups = append(groups, group)
+	}
+	for _, group := range groups {
+		group.height = bottom + 20 - group.y
+		nodes = append(nodes, group)
+	}
+
+	y := bottom + 60
+	x := 20
+	for _, caveat := range exchange.Schema.Caveats {
+		if !caveat.Duplicate {
+			nodes = append(nodes, exchangeNode{elementID: exchange.id(caveat), x: x, y: y, width: exchangeWidth, height: exchangeHeight})
+			x += exchangeColumn
+		}
+	}
+	if x > 20 {
+		y += exchangeRow
+	}
+	for index, message := range exchange.errors {
+		nodes = append(nodes, exchangeNode{label: message, elementID: fmt.Sprintf("e%d", index+1), x: 20, y: y, width: 2*exchangeColumn + exchangeWidth, height: 40})
+		y += 50
+	}
+	return nodes
+}
+
+// an error is a red note
+func (exchange *ArchimateExchangeSchema) appendNode(out []string, node exchangeNode, indent string) []string {
+	if node.label != "" {
+		out = append(out, fmt.Sprintf(`%s<node identifier="v-%s" xsi:type="Label" x="%d" y="%d" w="%d" h="%d">`, indent, node.elementID, node.x, node.y, node.width, node.height))
+		out = append(out, fmt.Sprintf(`%s  <label xml:lang="en">%s</label>`, indent, xmlText(node.label)))
+		r, g, b := exchangeColor(exchange.Options.errorColor("#ff0000"))
+		out = append(out, fmt.Sprintf(`%s  <style><fillColor r="%d" g="%d" b="%d"/><font><color r="255" g="255" b="255"/></font></style>`, indent, r, g, b))
+		return append(out, indent+"</node>")
+	}
+	attributes := fmt.Sprintf(`identifier="v-%s" elementRef="id-%s" xsi:type="Element" x="%d" y="%d" w="%d" h="%d"`, node.elementID, node.elementID, node.x, node.y, node.width, node.height)
+	if len(node.children) == 0 {
+		return append(out, fmt.Sprintf("%s<node %s/>", indent, attributes))
+	}
+	out = append(out, fmt.Sprintf("%s<node %s>", indent, attributes))
+	for _, child := range node.children {
+		out = exchange.appendNode(out, child, indent+"  ")
+	}
+	return append(out, indent+"</node>")
+}
+
+func (exchange *ArchimateExchangeSchema) addElement(id string, kind string, name string, doc string) {
+	if exchange.HideDocs {
+		doc = ""
+	}
+	exchange.elements = append(exchange.elements, exchangeElement{id, kind, name, CommentText(doc)})
+}
+
+// a relationship is named a_source_target, with a suffix _2, _3... when several join the same elements
+func (exchange *ArchimateExchangeSchema) addRelationship(kind string, source string, target string, name string, directed bool) {
+	id := "a_" + source + "_" + target
+	for n := 2; exchange.hasRelationship(id); n++ {
+		id = fmt.Sprintf("a_%s_%s_%d", source, target, n)
+	}
+	exchange.relationships = append(exchange.relationships, exchangeRelationship{id, kind, source, target, name, directed})
+}
+
+func (exchange *ArchimateExchangeSchema) hasRelationship(id string) bool {
+	for _, r := range exchange.relationships {
+		if r.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (exchange *ArchimateExchangeSchema) addError(format string, args ...interface{}) {
+	exchange.errors = append(exchange.errors, fmt.Sprintf(format, args...))
+}
+
+func (exchange *ArchimateExchangeSchema) id(element interface{}) string {
+	return exchange.ids[element]
+}
+
+// exchangeColor reads #rrggbb, #rgb or an SVG color name, the options validated have no other color : it is red
+func exchangeColor(color string) (r, g, b int) {
+	if r, g, b, ok := colorRGB(color); ok {
+		return r, g, b
+	}
+	return 255, 0, 0
+}
+
+func xmlText(text string) string {
+	var out strings.Builder
+	xml.EscapeText(&out, []byte(text))
+	return out.String()
+}
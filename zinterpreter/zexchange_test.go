@@ -0,0 +1,74 @@
+package zinterpreter
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestArchimateExchange(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {}
+definition acme/group { relation member: user | acme/group#member }
+caveat weekday(day int) { day < 6 }
+// documents & more
+definition document {
+	relation viewer: user with weekday | acme/group#member | user:* | usr
+	permission view = viewer + parent->view
+}`)
+
+	exchange := ArchimateExchangeSchema{Schema: schema}
+	out := exchange.Generate("doc")
+
+	// well formed, and every reference is declared
+	var model struct {
+		Elements []struct {
+			ID string `xml:"identifier,attr"`
+		} `xml:"elements>element"`
+		Relationships []struct {
+			ID     string `xml:"identifier,attr"`
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+		} `xml:"relationships>relationship"`
+	}
+	if err := xml.Unmarshal([]byte(out), &model); err != nil {
+		t.Fatalf("did not expect an error: %v\n%s", err, out)
+	}
+	declared := make(map[string]bool)
+	for _, e := range model.Elements {
+		declared[e.ID] = true
+	}
+	if len(model.Elements) != 8 || len(model.Relationships) != 11 {
+		t.Errorf("expected 8 elements and 11 relationships, got %d and %d", len(model.Elements), len(model.Relationships))
+	}
+	for _, r := range model.Relationships {
+		if !declared[r.Source] || !declared[r.Target] {
+			t.Errorf("relationship %s between undeclared elements %s and %s", r.ID, r.Source, r.Target)
+		}
+	}
+
+	for _, line := range []string{
+		`<element identifier="id-d_document" xsi:type="BusinessObject">`,
+		`<documentation xml:lang="en">documents &amp; more</documentation>`,
+		`<name xml:lang="en">view = viewer + parent-&gt;view</name>`,
+		`<element identifier="id-g_acme" xsi:type="Grouping">`,
+		`<element identifier="id-c_weekday" xsi:type="Constraint">`,
+		`source="id-r_document_viewer" target="id-d_user" xsi:type="Association" isDirected="true">`,
+		`<name xml:lang="en">with weekday</name>`,
+		`source="id-r_document_viewer" target="id-c_weekday" xsi:type="Association"/>`,
+		`<node identifier="v-d_acme_group" elementRef="id-d_acme_group" xsi:type="Element"`,
+		`<label xml:lang="en">definition usr does not exist, did you mean user?</label>`,
+		`<connection identifier="v-a_d_acme_group_r_acme_group_member" relationshipRef="id-a_d_acme_group_r_acme_group_member" xsi:type="Relationship" source="v-d_acme_group" target="v-r_acme_group_member"/>`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in:\n%s", line, out)
+		}
+	}
+
+	// the exchange format has no color names
+	for color, expected := range map[string]string{"": `r="255" g="0" b="0"`, "#0a0": `r="0" g="170" b="0"`, "LightBlue": `r="173" g="216" b="230"`} {
+		exchange.Options = RenderOptions{ErrorColor: color}
+		if out := exchange.Generate("doc"); !strings.Contains(out, `<fillColor `+expected+`/>`) {
+			t.Errorf("expected the error color %q as %s", color, expected)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package zinterpreter
+
+// Automatic fixes
+//
+// Fixes returns the text edits repairing the problems found by Compile that have one obvious repair :
+// a subject type written twice in a relation is removed with its '|',
+// a definition used but not declared is declared empty at the end of the file using it.
+// the edits use the offsets of the spans, so the comments and the layout of the schema are kept
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Edit replaces the bytes Start to End of File by Text
+type Edit struct {
+	File  string
+	Start int
+	End   int
+	Text  string
+}
+
+// Fix repairs a diagnostic with one or more edits
+type Fix struct {
+	Code        string // the code of the diagnostic repaired
+	Description string
+	Span        Span
+	Edits       []Edit
+}
+
+func (f Fix) String() string {
+	return fmt.Sprintf("%s: fixed: %s [%s]", f.Span, f.Description, f.Code)
+}
+
+// Fixes returns the fixes of the schema
+// sources are the texts of the schema by file name, as given to NewFileLexer
+func Fixes(schema *Schema, sources map[string]string) []Fix {
+	var fixes []Fix
+	stubs := make(map[string]bool)
+	appended := make(map[string]bool) // the files ending with a stub
+
+	for _, d := range schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		for _, r := range d.Relations {
+			for _, s := range r.Subjects {
+				if s.Duplicate {
+					if fix, ok := removeSubject(s, r, sources); ok {
+						fixes = append(fixes, fix)
+					}
+				}
+			}
+		}
+		for _, r := range d.Relations {
+			for _, s := range r.Subjects {
+				if s.Target != nil || stubs[s.Name] {
+					continue
+				}
+				if fix, ok := declareStub(s, sources, appended[s.Span.Start.File]); ok {
+					stubs[s.Name] = true
+					appended[s.Span.Start.File] = true
+					fixes = append(fixes, fix)
+				}
+			}
+		}
+	}
+	return fixes
+}
+
+// the subject is removed from the end of the subject before it, so "user | user" becomes "user"
+func removeSubject(s *Subject, r *Relation, sources map[string]string) (Fix, bool) {
+	text, ok := sources[s.Span.Start.File]
+	if !ok {
+		return Fix{}, false
+	}
+	pipe := strings.TrimRightFunc(text[:s.Span.Start.Offset], unicode.IsSpace)
+	if !strings.HasSuffix(pipe, "|") {
+		// a comment between '|' and the subject : not fixed
+		return Fix{}, false
+	}
+	start := len(strings.TrimRightFunc(pipe[:len(pipe)-1], unicode.IsSpace))
+	return Fix{
+		Code:        CodeDuplicateSubject,
+		Description: fmt.Sprintf("removed %s declared more than once in relation %s of definition %s", withCaveat(s.Label(), s.CaveatName), r.Name(), r.Definition.Name()),
+		Span:        s.Span,
+		Edits:       []Edit{{File: s.Span.Start.File, Start: start, End: s.Span.End.Offset}},
+	}, true
+}
+
+// definition name {} at the end of the file, with the line endings of the file
+// after is true when a stub is already appended to the file
+func declareStub(s *Subject, sources map[string]string, after bool) (Fix, bool) {
+	text, ok := sources[s.Span.Start.File]
+	if !ok {
+		return Fix{}, false
+	}
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+	}
+	stub := "definition " + s.Name + " {}" + newline
+	switch {
+	case text == "":
+	case after || strings.HasSuffix(text, newline):
+		stub = newline + stub
+	default:
+		stub = newline + newline + stub
+	}
+	return Fix{
+		Code:        CodeUnknownDefinition,
+		Description: fmt.Sprintf("declared definition %s", s.Name),
+		Span:        s.Span,
+		Edits:       []Edit{{File: s.Span.Start.File, Start: len(text), End: len(text), Text: stub}},
+	}, true
+}
+
+// ApplyEdits returns the text with the edits of file applied
+// the edits must not overlap, the insertions at the same offset are kept in their order
+func ApplyEdits(text string, file string, edits []Edit) string {
+	var mine []Edit
+	for _, e := range edits {
+		if e.File == file {
+			mine = append(mine, e)
+		}
+	}
+	sort.SliceStable(mine, func(i, j int) bool {
+		return mine[i].Start < mine[j].Start
+	})
+
+	var out strings.Builder
+	last := 0
+	for _, e := range mine {
+		if e.Start < last {
+			continue
+		}
+		out.WriteString(text[last:e.Start])
+		out.WriteString(e.Text)
+		last = e.End
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
@@ -0,0 +1,76 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func fixInput(t *testing.T, input string) (string, []Fix) {
+	schema, _ := compileInput(t, input)
+	fixes := Fixes(schema, map[string]string{"": input})
+	var edits []Edit
+	for _, fix := range fixes {
+		edits = append(edits, fix.Edits...)
+	}
+	return ApplyEdits(input, "", edits), fixes
+}
+
+func TestFixes(t *testing.T) {
+	input := `definition user {}
+
+// documents
+definition document {
+	relation viewer: user | group#member | user:* | user /* again */ | group#member
+	relation editor: user|user with weekday|user:* // in editor
+	relation owner: usr | user:*
+}
+
+caveat weekday(day int) { day < 6 }`
+
+	expected := `definition user {}
+
+// documents
+definition document {
+	relation viewer: user | group#member | user:* /* again */
+	relation editor: user|user with weekday|user:* // in editor
+	relation owner: usr | user:*
+}
+
+caveat weekday(day int) { day < 6 }
+
+definition group {}
+
+definition usr {}
+`
+	got, fixes := fixInput(t, input)
+	if got != expected {
+		t.Errorf("unexpected fixed schema:\n%s", UnifiedDiff("expected", "got", expected, got))
+	}
+
+	var descriptions []string
+	for _, fix := range fixes {
+		descriptions = append(descriptions, fix.Description)
+	}
+	expectedDescriptions := []string{
+		"removed user declared more than once in relation viewer of definition document",
+		"removed group#member declared more than once in relation viewer of definition document",
+		"declared definition group",
+		"declared definition usr",
+	}
+	if strings.Join(descriptions, "\n") != strings.Join(expectedDescriptions, "\n") {
+		t.Errorf("unexpected fixes:\n%s", strings.Join(descriptions, "\n"))
+	}
+
+	// the fixed schema has nothing left to fix
+	if again, fixes := fixInput(t, got); again != got || len(fixes) != 0 {
+		t.Errorf("expected no fix on the fixed schema, got %v", fixes)
+	}
+}
+
+func TestFixesKeepLineEndings(t *testing.T) {
+	input := "definition document {\r\n\trelation viewer: user:* | user:*\r\n}\r\n"
+	expected := "definition document {\r\n\trelation viewer: user:*\r\n}\r\n\r\ndefinition user {}\r\n"
+	if got, _ := fixInput(t, input); got != expected {
+		t.Errorf("unexpected fixed schema: %q", got)
+	}
+}
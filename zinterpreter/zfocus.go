@@ -0,0 +1,217 @@
+package zinterpreter
+
+// Focus
+//
+// Focus keeps the neighborhood of a definition, or of a relation definition#relation, in a smaller schema
+// that every renderer draws as the whole schema :
+// the definitions used as subject types, hop after hop up to depth,
+// and with reverse the definitions using them as subject types too
+// the definitions reached at the last hop are boundaries : they are drawn without their relations and permissions,
+// but the relations used by a subject set, so that the set is drawn,
+// and with reverse the relations pointing to the definitions of the previous hop, so that the arrows are drawn
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Focus returns the schema around target, "definition" or "definition#relation", up to depth hops
+func (s *Schema) Focus(target string, depth int, reverse bool) (*Schema, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("the depth must be 1 or more")
+	}
+	name, relationName, isRelation := strings.Cut(target, "#")
+	start := s.Definition(name)
+	if start == nil {
+		return nil, fmt.Errorf("definition %s does not exist%s", name, DidYouMean(s.SuggestDefinitions(name, nil)))
+	}
+
+	// the relations drawn of each definition, nil for all of them
+	kept := map[*Definition][]*Relation{start: nil}
+	if isRelation {
+		r := start.Relation(relationName)
+		if r == nil {
+			return nil, fmt.Errorf("relation %s does not exist in definition %s%s", relationName, start.Name(), DidYouMean(start.SuggestRelations(relationName)))
+		}
+		kept[start] = []*Relation{r}
+	}
+
+	expanded := map[*Definition]bool{}
+	// with reverse, the relations of a boundary pointing to a definition of the previous hop
+	incoming := map[*Relation]bool{}
+	frontier := []*Definition{start}
+	for hop := 1; hop <= depth; hop++ {
+		var next []*Definition
+		reach := func(d *Definition) {
+			if d == nil || d.Duplicate {
+				return
+			}
+			if _, seen := kept[d]; seen {
+				return
+			}
+			kept[d] = []*Relation{}
+			next = append(next, d)
+		}
+		reachFrom := func(d *Definition, r *Relation) {
+			reach(d)
+			if relations, ok := kept[d]; ok && relations != nil && !expanded[d] && !containsRelation(relations, r) {
+				kept[d] = append(relations, r)
+				incoming[r] = true
+			}
+		}
+		for _, d := range frontier {
+			expanded[d] = true
+			for _, r := range focusedRelations(d, kept[d]) {
+				for _, subject := range r.Subjects {
+					reach(subject.Target)
+				}
+			}
+			if !reverse {
+				continue
+			}
+			for _, other := range s.Definitions {
+				for _, r := range other.Relations {
+					for _, subject := range r.Subjects {
+						if subject.Target == d && (d != start || !isRelation || subject.TargetRelation == kept[start][0]) {
+							reachFrom(other, r)
+						}
+					}
+				}
+			}
+		}
+		// the definitions reached before the last hop show all their relations
+		if hop < depth {
+			for _, d := range next {
+				kept[d] = nil
+			}
+		}
+		frontier = next
+	}
+
+	// a boundary, or the focused relation, keeps the relations of the subject sets pointing to it, without their subjects
+	sets := map[*Relation]bool{}
+	for d := range expanded {
+		for _, r := range focusedRelations(d, kept[d]) {
+			for _, subject := range r.Subjects {
+				if subject.TargetRelation != nil && kept[subject.Target] != nil {
+					sets[subject.TargetRelation] = true
+				}
+			}
+		}
+	}
+
+	return s.subSchema(kept, expanded, sets, incoming), nil
+}
+
+// the relations of d kept by Focus, all of them when kept is nil
+func focusedRelations(d *Definition, kept []*Relation) []*Relation {
+	if kept == nil {
+		return d.Relations
+	}
+	return kept
+}
+
+func containsRelation(relations []*Relation, r *Relation) bool {
+	for _, other := range relations {
+		if other == r {
+			return true
+		}
+	}
+	return false
+}
+
+// subSchema copies the kept definitions and relations, in the order of the schema
+// the subjects, permissions and caveats are copied for the relations of the expanded definitions only, not for the sets
+// an incoming relation keeps the subjects pointing to the copied definitions and relations
+func (s *Schema) subSchema(kept map[*Definition][]*Relation, expanded map[*Definition]bool, sets map[*Relation]bool, incoming map[*Relation]bool) *Schema {
+	sub := &Schema{
+		definitionMap: make(map[string]*Definition),
+		caveatMap:     make(map[string]*Caveat),
+	}
+	definitions := make(map[*Definition]*Definition)
+	relations := make(map[*Relation]*Relation)
+	permissions := make(map[*Permission]*Permission)
+	withSubjects := make(map[*Relation]bool)
+
+	for _, d := range s.Definitions {
+		keptRelations, ok := kept[d]
+		if !ok {
+			continue
+		}
+		copied := &Definition{Source: d.Source, Duplicate: d.Duplicate, relationMap: make(map[string]*Relation), permissionMap: make(map[string]*Permission)}
+		definitions[d] = copied
+		sub.Definitions = append(sub.Definitions, copied)
+		if !d.Duplicate {
+			sub.definitionMap[d.Name()] = copied
+		}
+		for _, r := range d.Relations {
+			full := expanded[d] && (keptRelations == nil || containsRelation(keptRelations, r))
+			if full || sets[r] || incoming[r] {
+				if full || incoming[r] {
+					withSubjects[r] = true
+				}
+				relations[r] = &Relation{Source: r.Source, Definition: copied, Duplicate: r.Duplicate}
+				copied.Relations = append(copied.Relations, relations[r])
+				if !r.Duplicate {
+					copied.relationMap[r.Name()] = relations[r]
+				}
+			}
+		}
+		if keptRelations == nil && expanded[d] {
+			for _, p := range d.Permissions {
+				permissions[p] = &Permission{Source: p.Source, Definition: copied, Duplicate: p.Duplicate}
+				copied.Permissions = append(copied.Permissions, permissions[p])
+				if !p.Duplicate {
+					copied.permissionMap[p.Name()] = permissions[p]
+				}
+			}
+		}
+	}
+
+	usedCaveats := make(map[*Caveat]bool)
+	for d := range definitions {
+		for _, r := range d.Relations {
+			if !withSubjects[r] {
+				continue
+			}
+			for _, subject := range r.Subjects {
+				if !expanded[d] && (definitions[subject.Target] == nil || subject.TargetRelation != nil && relations[subject.TargetRelation] == nil) {
+					continue
+				}
+				copiedSubject := *subject
+				copiedSubject.Target = definitions[subject.Target]
+				copiedSubject.TargetRelation = relations[subject.TargetRelation]
+				relations[r].Subjects = append(relations[r].Subjects, &copiedSubject)
+				if subject.Caveat != nil {
+					usedCaveats[subject.Caveat] = true
+				}
+			}
+		}
+		for _, original := range d.Permissions {
+			p := permissions[original]
+			if p == nil {
+				continue
+			}
+			for _, ref := range original.References {
+				p.References = append(p.References, &Reference{Source: ref.Source, Relation: relations[ref.Relation], Permission: permissions[ref.Permission]})
+			}
+			for _, arrow := range original.Arrows {
+				copiedArrow := &Arrow{Source: arrow.Source, Relation: relations[arrow.Relation]}
+				for _, missing := range arrow.MissingIn {
+					if definitions[missing] != nil {
+						copiedArrow.MissingIn = append(copiedArrow.MissingIn, definitions[missing])
+					}
+				}
+				p.Arrows = append(p.Arrows, copiedArrow)
+			}
+		}
+	}
+
+	for _, caveat := range s.Caveats {
+		if usedCaveats[caveat] {
+			sub.Caveats = append(sub.Caveats, caveat)
+			sub.caveatMap[caveat.Name()] = caveat
+		}
+	}
+	return sub
+}
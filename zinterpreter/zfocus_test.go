@@ -0,0 +1,108 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+const focusSchema = `definition user {}
+definition team { relation member: user }
+definition organization { relation admin: user | team#member }
+definition folder { relation owner: organization#admin relation parent: folder }
+definition document {
+	relation parent: folder
+	relation viewer: user | team#member
+	permission view = viewer + parent->owner
+}`
+
+// definition: relation, relation... for each definition of the focused schema
+func focusSummary(schema *Schema) string {
+	var lines []string
+	for _, d := range schema.Definitions {
+		var members []string
+		for _, r := range d.Relations {
+			members = append(members, r.Name())
+		}
+		for _, p := range d.Permissions {
+			members = append(members, p.Name())
+		}
+		lines = append(lines, d.Name()+": "+strings.Join(members, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestFocus(t *testing.T) {
+	schema, _ := compileInput(t, focusSchema)
+	tests := []struct {
+		target   string
+		depth    int
+		reverse  bool
+		expected string
+	}{
+		{"document", 1, false, "user: \nteam: member\nfolder: \ndocument: parent, viewer, view"},
+		{"document", 2, false, "user: \nteam: member\norganization: admin\nfolder: owner, parent\ndocument: parent, viewer, view"},
+		{"document#viewer", 1, false, "user: \nteam: member\ndocument: viewer"},
+		{"team", 1, true, "user: \nteam: member\norganization: admin\ndocument: viewer"},
+		{"team#member", 1, true, "user: \nteam: member\norganization: admin\ndocument: viewer"},
+		{"user", 1, true, "user: \nteam: member\norganization: admin\ndocument: viewer"},
+	}
+	for _, tt := range tests {
+		focused, err := schema.Focus(tt.target, tt.depth, tt.reverse)
+		if err != nil {
+			t.Fatalf("%s: did not expect an error: %v", tt.target, err)
+		}
+		if got := focusSummary(focused); got != tt.expected {
+			t.Errorf("%s depth %d reverse %v: expected\n%s\ngot\n%s", tt.target, tt.depth, tt.reverse, tt.expected, got)
+		}
+	}
+
+	for target, expected := range map[string]string{
+		"documnt":       "definition documnt does not exist, did you mean document?",
+		"document#view": "relation view does not exist in definition document",
+	} {
+		if _, err := schema.Focus(target, 1, false); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %s, got %v", target, expected, err)
+		}
+	}
+}
+
+// the focused schema is drawn without error : every subject points to a definition of the focused schema
+func TestFocusRender(t *testing.T) {
+	schema, _ := compileInput(t, focusSchema)
+	focused, _ := schema.Focus("document", 1, false)
+	team := focused.Definition("team")
+	if team == nil || team.Relation("member") == nil || len(team.Relation("member").Subjects) != 0 {
+		t.Fatalf("expected team#member without its subjects")
+	}
+	for _, r := range focused.Definition("document").Relations {
+		for _, s := range r.Subjects {
+			if s.Target != focused.Definition(s.Name) {
+				t.Errorf("%s points out of the focused schema", s.Label())
+			}
+		}
+	}
+
+	out := plantUMLRenderer{}.Render(focused, RenderOptions{Name: "doc"})
+	if strings.Contains(out, "#red") {
+		t.Errorf("did not expect an error in:\n%s", out)
+	}
+
+	// with reverse, the boundaries keep the subjects pointing to the focused schema
+	focused, _ = schema.Focus("team", 1, true)
+	var labels []string
+	for _, s := range focused.Definition("organization").Relation("admin").Subjects {
+		labels = append(labels, s.Label())
+	}
+	if strings.Join(labels, " ") != "user team#member" {
+		t.Errorf("expected the subjects of organization#admin, got %v", labels)
+	}
+	out = plantUMLRenderer{}.Render(focused, RenderOptions{Name: "doc"})
+	for _, line := range []string{"Rel_Access_w(r_team_member,r_organization_admin,\"team#member\")", "Rel_Access_w(r_team_member,r_document_viewer,\"team#member\")"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "#red") {
+		t.Errorf("did not expect an error in:\n%s", out)
+	}
+}
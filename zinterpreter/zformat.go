@@ -0,0 +1,313 @@
+package zinterpreter
+
+// Canonical printing of a schema
+//
+// caveats and definitions are printed in the order of the schema, separated by an empty line
+// a relation or a permission is printed on one line, indented with a tab
+// the parentheses of a permission are kept as written, the ones needed by the precedence are added
+// subject types are printed as objects, then wildcards, then subject sets, each in the order of the schema
+// the comments are printed before the element they are attached to
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Format returns the canonical text of a schema read by ReadZSchema
+// endDoc is Lexer.EndDoc
+func Format(zdefs []*ZDef, zcaveats []*ZCaveat, endDoc string) string {
+	type element struct {
+		offset int
+		text   string
+	}
+	var elements []element
+
+	for _, zcaveat := range zcaveats {
+		elements = append(elements, element{zcaveat.Span.Start.Offset, formatZCaveat(zcaveat)})
+	}
+	for _, zdef := range zdefs {
+		elements = append(elements, element{zdef.Span.Start.Offset, formatZDef(zdef)})
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		return elements[i].offset < elements[j].offset
+	})
+
+	var out []string
+	for _, e := range elements {
+		out = append(out, e.text)
+	}
+	if endDoc != "" {
+		out = append(out, formatDoc(endDoc, ""))
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n\n") + "\n"
+}
+
+func formatZCaveat(zcaveat *ZCaveat) string {
+	var out []string
+	if zcaveat.Doc != "" {
+		out = append(out, formatDoc(zcaveat.Doc, ""))
+	}
+	out = append(out, fmt.Sprintf("caveat %s {", zcaveat.Signature()))
+	out = append(out, indentExpression(zcaveat.Expression, "\t"))
+	out = append(out, "}")
+	return strings.Join(out, "\n")
+}
+
+func formatZDef(zdef *ZDef) string {
+	var out []string
+	if zdef.Doc != "" {
+		out = append(out, formatDoc(zdef.Doc, ""))
+	}
+	if len(zdef.Relations) == 0 && len(zdef.Permissions) == 0 && zdef.EndDoc == "" {
+		out = append(out, fmt.Sprintf("definition %s {}", zdef.FullName()))
+		return strings.Join(out, "\n")
+	}
+	out = append(out, fmt.Sprintf("definition %s {", zdef.FullName()))
+
+	// relations and permissions in the order of the schema
+	type member struct {
+		offset       int
+		isPermission bool
+		doc          string
+		text         string
+	}
+	var members []member
+	for _, zrel := range zdef.Relations {
+		members = append(members, member{zrel.Span.Start.Offset, false, zrel.Doc, formatZRelation(zrel)})
+	}
+	for _, zperm := range zdef.Permissions {
+		members = append(members, member{zperm.Span.Start.Offset, true, zperm.Doc, formatZPermission(zperm)})
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].offset < members[j].offset
+	})
+
+	for i, m := range members {
+		// an empty line before a commented member and between relations and permissions
+		if i > 0 && (m.doc != "" || m.isPermission != members[i-1].isPermission) {
+			out = append(out, "")
+		}
+		if m.doc != "" {
+			out = append(out, formatDoc(m.doc, "\t"))
+		}
+		out = append(out, "\t"+m.text)
+	}
+	if zdef.EndDoc != "" {
+		if len(members) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, formatDoc(zdef.EndDoc, "\t"))
+	}
+	out = append(out, "}")
+	return strings.Join(out, "\n")
+}
+
+func formatZRelation(zrel *ZRelation) string {
+	var subjects []string
+	for _, zobject := range zrel.Zobjects {
+		subjects = append(subjects, withCaveat(zobject.Name, zobject.Caveat))
+	}
+	for _, zobjectWildCard := range zrel.ZobjectWildCards {
+		subjects = append(subjects, withCaveat(zobjectWildCard.Name+":*", zobjectWildCard.Caveat))
+	}
+	for _, zobjectSet := range zrel.ZobjectSets {
+		subjects = append(subjects, withCaveat(zobjectSet.Name+"#"+zobjectSet.Relation, zobjectSet.Caveat))
+	}
+	return fmt.Sprintf("relation %s: %s", zrel.Name, strings.Join(subjects, " | "))
+}
+
+func formatZPermission(zperm *ZPermission) string {
+	return fmt.Sprintf("permission %s = %s", zperm.Name, zperm.Expression.String())
+}
+
+// formatDoc prints the comments as written, indented
+// the inner lines of a block comment starting with '*' are aligned on the first '*' of "/*"
+func formatDoc(doc string, indent string) string {
+	var out []string
+	inBlock := false
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case inBlock && strings.HasPrefix(line, "*"):
+			out = append(out, indent+" "+line)
+		case line == "":
+			out = append(out, "")
+		default:
+			out = append(out, indent+line)
+		}
+		if strings.HasPrefix(line, "/*") && !inBlock {
+			inBlock = true
+		}
+		if inBlock && strings.HasSuffix(line, "*/") {
+			inBlock = false
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// indentExpression indents a caveat expression, keeping the relative indentation of its lines
+func indentExpression(expression string, indent string) string {
+	lines := strings.Split(expression, "\n")
+	common := ""
+	first := true
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		leading := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			common = leading
+			first = false
+		} else {
+			common = commonPrefix(common, leading)
+		}
+	}
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if i > 0 {
+			line = strings.TrimPrefix(line, common)
+		}
+		if line != "" {
+			line = indent + line
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func commonPrefix(common string, leading string) string {
+	i := 0
+	for i < len(common) && i < len(leading) && common[i] == leading[i] {
+		i++
+	}
+	return common[:i]
+}
+
+// UnifiedDiff returns the differences between two texts as a unified diff with 3 lines of context
+// it returns "" when the texts are equal, a missing final newline is a difference
+func UnifiedDiff(oldName string, newName string, oldText string, newText string) string {
+	const context = 3
+	a := splitLines(oldText)
+	b := splitLines(newText)
+
+	// longest common subsequence of lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// edit script : ' ' kept, '-' removed, '+' added
+	type edit struct {
+		kind byte
+		line string
+		i, j int // lines of a and b before the edit
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case j >= len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var out []string
+	for k := 0; k < len(edits); {
+		if edits[k].kind == ' ' {
+			k++
+			continue
+		}
+		// a hunk goes from context lines before the change to context lines after the last close change
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(edits) {
+			if edits[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+			if next < len(edits) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end += context
+			if end > len(edits) {
+				end = len(edits)
+			}
+			break
+		}
+
+		countA, countB := 0, 0
+		var lines []string
+		for _, e := range edits[start:end] {
+			if e.kind != '+' {
+				countA++
+			}
+			if e.kind != '-' {
+				countB++
+			}
+			lines = append(lines, string(e.kind)+strings.TrimSuffix(e.line, "\n"))
+			if !strings.HasSuffix(e.line, "\n") {
+				lines = append(lines, "\\ No newline at end of file")
+			}
+		}
+		if len(out) == 0 {
+			out = append(out, "--- "+oldName, "+++ "+newName)
+		}
+		out = append(out, fmt.Sprintf("@@ -%s +%s @@", hunkRange(edits[start].i, countA), hunkRange(edits[start].j, countB)))
+		out = append(out, lines...)
+		k = end
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// the lines keep their '\n', so that the last line differs from the same line without it
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,152 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func formatInput(t *testing.T, input string) string {
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+	return Format(z, lexer.ZCaveats(), lexer.EndDoc())
+}
+
+func TestFormat(t *testing.T) {
+	input := `// users
+definition   user {  }
+caveat weekday(day int,   tags list<string>) {
+      day < 6 &&
+        "a" in tags
+}
+/**
+   * a document
+   */
+definition acme/document {
+    relation viewer: group#member   |  user:* with weekday | user
+  relation owner : user
+    // who can view
+	permission view = (viewer + owner) - (banned - owner)
+	permission edit = owner & parent->edit + parent.any(view)
+	// end of document
+}
+definition group { relation member: user | group#member /* inner */ }
+// end of file`
+
+	expected := `// users
+definition user {}
+
+caveat weekday(day int, tags list<string>) {
+	day < 6 &&
+	"a" in tags
+}
+
+/**
+ * a document
+ */
+definition acme/document {
+	relation viewer: user | user:* with weekday | group#member
+	relation owner: user
+
+	// who can view
+	permission view = (viewer + owner) - (banned - owner)
+	permission edit = owner & parent->edit + parent.any(view)
+
+	// end of document
+}
+
+definition group {
+	/* inner */
+	relation member: user | group#member
+}
+
+// end of file
+`
+	got := formatInput(t, input)
+	if got != expected {
+		t.Errorf("unexpected format:\n%s\n---\n%s", got, UnifiedDiff("expected", "got", expected, got))
+	}
+
+	// formatting a formatted schema changes nothing
+	if again := formatInput(t, got); again != got {
+		t.Errorf("format is not idempotent:\n%s", UnifiedDiff("formatted", "again", got, again))
+	}
+}
+
+// the parentheses written are kept, the needed ones are added
+func TestFormatParentheses(t *testing.T) {
+	for _, tt := range []struct {
+		expression string
+		expected   string
+	}{
+		{"viewer + owner - (viewer & owner)", "viewer + owner - (viewer & owner)"},
+		{"viewer + owner - viewer & owner", "viewer + owner - viewer & owner"},
+		{"(viewer + owner) & owner", "(viewer + owner) & owner"},
+		{"viewer - (owner - banned)", "viewer - (owner - banned)"},
+		{"((viewer))", "viewer"},
+	} {
+		got := formatInput(t, "definition doc { permission view = "+tt.expression+" }")
+		if expected := "definition doc {\n\tpermission view = " + tt.expected + "\n}\n"; got != expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.expression, expected, got)
+		}
+	}
+}
+
+func TestFormatKeepsComments(t *testing.T) {
+	input := `definition user {} // after user
+definition doc { // after brace
+	relation viewer: user | // in relation
+		group#member
+	permission view = viewer // after view
+	+ /* in expression */ owner
+}
+caveat c(a int) { a > 1 // in caveat
+}`
+	got := formatInput(t, input)
+	for _, comment := range []string{"// after user", "// after brace", "// in relation", "// after view", "/* in expression */", "// in caveat"} {
+		if strings.Count(got, comment) != 1 {
+			t.Errorf("expected %s once in:\n%s", comment, got)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	if d := UnifiedDiff("a", "b", "x\ny\n", "x\ny\n"); d != "" {
+		t.Errorf("expected no diff, got %s", d)
+	}
+
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	new := "1\n2\n3\nfour\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+	expected := `--- a
++++ b
+@@ -1,7 +1,7 @@
+ 1
+ 2
+ 3
+-4
++four
+ 5
+ 6
+ 7
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+	if d := UnifiedDiff("a", "b", old, new); d != expected {
+		t.Errorf("unexpected diff:\n%s", d)
+	}
+
+	if d := UnifiedDiff("a", "b", "", "x\n"); d != "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+x\n" {
+		t.Errorf("unexpected diff:\n%s", d)
+	}
+
+	// the final newline is compared too
+	if d := UnifiedDiff("a", "b", "x\ny", "x\ny\n"); d != "--- a\n+++ b\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+y\n" {
+		t.Errorf("unexpected diff:\n%s", d)
+	}
+}
@@ -3,21 +3,45 @@ package zinterpreter
 // zanzibar restricted BNF grammar
 
 /**
-<Zschema> ::= <Zdef>*
+Line comments "//" and block comments are skipped by the lexer.
+The comments just before "definition", "relation", "permission" and "caveat" are their Doc.
+A comment starting on the last line of an element is added to its Doc.
+
+<Zschema> ::= (<Zdef> | <Zcaveat>)*
 <Zdef> ::= "definition" <Zname> "{" <Zbody> "}"  ---> generation
-<Zname> ::= <identifier>
-<Zbody> ::= <Zrelation>*
+<Zname> ::= [<Zprefix> "/"] <identifier>
+<Zprefix> ::= <identifier> ("/" <identifier>)*
+<Zbody> ::= (<Zrelation> | <Zpermission>)*
 <Zrelation> ::= "relation" <Rname> ":" <Sname> ("|" <Sname)*   ---> generation
 <Rname> ::= <identifier>
-<Sname> ::= <Zname> | <Zname> "#" <Rname> | <Zname> ":" "*"
+<Sname> ::= (<Zname> | <Zname> "#" <Rname> | <Zname> ":" "*") ["with" <Cname>]
+<Zpermission> ::= "permission" <Pname> "=" <Zexclusion>   ---> generation
+<Pname> ::= <identifier>
+<Zexclusion> ::= <Zintersection> ("-" <Zintersection>)*
+<Zintersection> ::= <Zunion> ("&" <Zunion>)*
+<Zunion> ::= <Zterm> ("+" <Zterm>)*
+<Zterm> ::= <Zarrow> | <identifier> | "nil" | "(" <Zexclusion> ")"
+<Zarrow> ::= <Rname> "->" <identifier> | <Rname> "." <Afunction> "(" <identifier> ")"
+<Afunction> ::= "any" | "all"
+<Zcaveat> ::= "caveat" <Cname> "(" <Cparam> ("," <Cparam>)* ")" "{" <Cexpression> "}"   ---> generation
+<Cname> ::= <identifier>
+<Cparam> ::= <identifier> <Ctype>
+<Ctype> ::= <identifier> ["<" <Ctype> ">"]
+<Cexpression> ::= any tokens with balanced braces, kept as written
 <identifier> ::= [a-zA-Z_][a-zA-Z0-9_]*
 
+Keywords are only recognized as whole identifiers : "relationship" is an identifier.
+As in SpiceDB, "+" binds tighter than "&" which binds tighter than "-".
+
 */
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Token represents the different tokens
@@ -31,6 +55,24 @@ const (
 	LeftBraceToken               // "{"
 	RightBraceToken              // "}"
 	HashToken                    // "#"
+	PermissionToken              // "permission"
+	NilToken                     // "nil"
+	EqualToken                   // "="
+	PlusToken                    // "+"
+	AmpersandToken               // "&"
+	MinusToken                   // "-"
+	LeftParenToken               // "("
+	RightParenToken              // ")"
+	ArrowToken                   // "->"
+	DotToken                     // "."
+	SlashToken                   // "/"
+	CaveatToken                  // "caveat"
+	WithToken                    // "with"
+	CommaToken                   // ","
+	LessToken                    // "<"
+	GreaterToken                 // ">"
+	StringToken                  // "..." or '...'
+	NumberToken                  // [0-9]+("."[0-9]+)?
 	IdentifierToken              // [a-zA-Z_][a-zA-Z0-9_]*
 	WildCard                     // *
 	EOFToken                     // ''
@@ -41,14 +83,76 @@ const (
 type Item struct {
 	Token Token
 	Value string
+	Span  Span
+	Doc   string // comments read before the token, as written
+	// the comments starting on the line of the previous token, first lines of Doc
+	// they are the Doc of the element ending with the previous token when the parser takes them
+	Trailing string
+}
+
+// Position of a character in a schema file
+// Line and Col start at 1, Offset is the byte offset from the beginning of the file
+type Position struct {
+	File   string
+	Line   int
+	Col    int
+	Offset int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Span of a token or of a node, End is just after the last character
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) String() string {
+	return s.Start.String()
+}
+
+// SyntaxError is an error of the parser at a position
+type SyntaxError struct {
+	Pos     Position
+	End     Position // end of the offending token
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Pos.String() + ": " + e.Message
+}
+
+// ErrorList is every SyntaxError of a schema, one per line
+type ErrorList []*SyntaxError
+
+func (list ErrorList) Error() string {
+	messages := []string{}
+	for _, e := range list {
+		messages = append(messages, e.Error())
+	}
+	return strings.Join(messages, "\n")
 }
 
 // Lexer parses input text and generates tokens
 type Lexer struct {
 	input       string
+	file        string
 	pos         int
 	length      int
+	line        int // line of lineStart
+	lineStart   int // offset of the first character of the line
+	synced      int // offset up to which line and lineStart are computed
+	prevEnd     Position
 	currentItem *Item
+	zcaveats    []*ZCaveat
+	errors      ErrorList
+	innerDocs   []string // comments read inside the element being parsed
+	endDoc      string   // comments after the last element
 }
 
 // for Lexer message
@@ -68,6 +172,42 @@ func TokenToString(t Token) string {
 		return "}"
 	case HashToken:
 		return "#"
+	case PermissionToken:
+		return "permission"
+	case NilToken:
+		return "nil"
+	case EqualToken:
+		return "="
+	case PlusToken:
+		return "+"
+	case AmpersandToken:
+		return "&"
+	case MinusToken:
+		return "-"
+	case LeftParenToken:
+		return "("
+	case RightParenToken:
+		return ")"
+	case ArrowToken:
+		return "->"
+	case DotToken:
+		return "."
+	case SlashToken:
+		return "/"
+	case CaveatToken:
+		return "caveat"
+	case WithToken:
+		return "with"
+	case CommaToken:
+		return ","
+	case LessToken:
+		return "<"
+	case GreaterToken:
+		return ">"
+	case StringToken:
+		return "String"
+	case NumberToken:
+		return "Number"
 	case IdentifierToken:
 		return "Identifier"
 	case WildCard:
@@ -82,9 +222,16 @@ func TokenToString(t Token) string {
 }
 
 func NewLexer(input string) *Lexer {
+	return NewFileLexer("", input)
+}
+
+// NewFileLexer creates a lexer whose positions refer to filename
+func NewFileLexer(filename string, input string) *Lexer {
 	return &Lexer{
 		input:  input,
+		file:   filename,
 		length: len(input),
+		line:   1,
 		currentItem: &Item{
 			Token: InvalidToken,
 			Value: "",
@@ -92,16 +239,165 @@ func NewLexer(input string) *Lexer {
 	}
 }
 
-// We eat up the white spaces
-func (l *Lexer) eatSpace() {
-	for l.pos < l.length && unicode.IsSpace(rune(l.input[l.pos])) {
-		l.pos++
+// position returns the position of l.pos
+// Col counts the runes of the line, Offset counts the bytes
+func (l *Lexer) position() Position {
+	for ; l.synced < l.pos && l.synced < l.length; l.synced++ {
+		if l.input[l.synced] == '\n' {
+			l.line++
+			l.lineStart = l.synced + 1
+		}
+	}
+	col := utf8.RuneCountInString(l.input[l.lineStart:l.pos]) + 1
+	return Position{File: l.file, Line: l.line, Col: col, Offset: l.pos}
+}
+
+// errorf returns a SyntaxError at the position of the current token
+func (l *Lexer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Pos: l.currentItem.Span.Start, End: l.currentItem.Span.End, Message: fmt.Sprintf(format, args...)}
+}
+
+// We eat up the white spaces and the comments
+// comments are returned as written, one comment per line
+// the comments starting on the line of the previous token are returned apart as trailing
+func (l *Lexer) eatSpace() (doc string, trailing string) {
+	var comments, trailings []string
+	sameLine := l.prevEnd.Line > 0
+	for l.pos < l.length {
+		r, size := l.peek()
+		switch {
+		case unicode.IsSpace(r):
+			if r == '\n' {
+				sameLine = false
+			}
+			l.pos += size
+		case strings.HasPrefix(l.input[l.pos:], "//"):
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			if end < 0 {
+				end = l.length - l.pos
+			}
+			comment := strings.TrimRight(l.input[l.pos:l.pos+end], " \t\r")
+			if sameLine {
+				trailings = append(trailings, comment)
+			} else {
+				comments = append(comments, comment)
+			}
+			l.pos += end
+		case strings.HasPrefix(l.input[l.pos:], "/*"):
+			end := strings.Index(l.input[l.pos+2:], "*/")
+			if end < 0 {
+				// unterminated comment, NextToken reports it
+				return strings.Join(comments, "\n"), strings.Join(trailings, "\n")
+			}
+			comment := l.input[l.pos : l.pos+2+end+2]
+			if sameLine {
+				trailings = append(trailings, comment)
+			} else {
+				comments = append(comments, comment)
+			}
+			if strings.Contains(comment, "\n") {
+				sameLine = false
+			}
+			l.pos += 2 + end + 2
+		default:
+			return strings.Join(comments, "\n"), strings.Join(trailings, "\n")
+		}
+	}
+	return strings.Join(comments, "\n"), strings.Join(trailings, "\n")
+}
+
+// CommentText returns the text of a Doc without the comment markers
+func CommentText(doc string) string {
+	var lines []string
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "//"):
+			line = strings.TrimPrefix(line, "//")
+		case strings.HasPrefix(line, "/*"):
+			line = strings.TrimLeft(strings.TrimPrefix(line, "/*"), "*")
+		case strings.HasPrefix(line, "*") && !strings.HasPrefix(line, "*/"):
+			line = strings.TrimPrefix(line, "*")
+		}
+		line = strings.TrimSuffix(line, "*/")
+		line = strings.TrimSpace(line)
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// keywords are matched on whole identifiers only
+var keywords = map[string]Token{
+	"definition": DefinitionToken,
+	"relation":   RelationToken,
+	"permission": PermissionToken,
+	"nil":        NilToken,
+	"caveat":     CaveatToken,
+	"with":       WithToken,
+}
+
+// tokens of a single character
+var punctuations = map[rune]Token{
+	':': ColonToken,
+	'|': OrToken,
+	'{': LeftBraceToken,
+	'}': RightBraceToken,
+	'#': HashToken,
+	'*': WildCard,
+	'=': EqualToken,
+	'+': PlusToken,
+	'&': AmpersandToken,
+	'-': MinusToken,
+	'(': LeftParenToken,
+	')': RightParenToken,
+	'.': DotToken,
+	'/': SlashToken, // "//" and "/*" are comments eaten by eatSpace
+	',': CommaToken,
+	'<': LessToken,
+	'>': GreaterToken,
+}
+
+// <identifier> ::= [a-zA-Z_][a-zA-Z0-9_]*
+func isIdentifierStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isIdentifierPart(r rune) bool {
+	return isIdentifierStart(r) || isDigit(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// peek returns the rune at l.pos and its size in bytes
+func (l *Lexer) peek() (rune, int) {
+	if l.pos >= l.length {
+		return utf8.RuneError, 0
 	}
+	return utf8.DecodeRuneInString(l.input[l.pos:])
 }
 
 // Lexer returns the next token to read
 func (l *Lexer) NextToken() *Item {
-	l.eatSpace()
+	l.prevEnd = l.currentItem.Span.End
+	doc, trailing := l.eatSpace()
+	l.currentItem.Doc = joinDocs(trailing, doc)
+	l.currentItem.Trailing = trailing
+	start := l.position()
+	defer func() {
+		l.currentItem.Span = Span{Start: start, End: l.position()}
+		// the Doc of these tokens is kept by the parser, the other comments are inner comments
+		switch l.currentItem.Token {
+		case DefinitionToken, RelationToken, PermissionToken, CaveatToken, RightBraceToken, EOFToken:
+		default:
+			if l.currentItem.Doc != "" {
+				l.innerDocs = append(l.innerDocs, l.currentItem.Doc)
+			}
+		}
+	}()
 
 	if l.pos >= l.length {
 		l.currentItem.Token = EOFToken
@@ -109,69 +405,154 @@ func (l *Lexer) NextToken() *Item {
 		return l.currentItem
 	}
 
-	switch {
-	case strings.HasPrefix(l.input[l.pos:], "definition"):
-		l.currentItem.Token = DefinitionToken
-		l.currentItem.Value = "definition"
-		l.pos += len("definition")
-	case strings.HasPrefix(l.input[l.pos:], "relation"):
-		l.currentItem.Token = RelationToken
-		l.currentItem.Value = "relation"
-		l.pos += len("relation")
-	case l.input[l.pos] == ':':
-		l.currentItem.Token = ColonToken
-		l.currentItem.Value = ":"
-		l.pos++
-	case l.input[l.pos] == '|':
-		l.currentItem.Token = OrToken
-		l.currentItem.Value = "|"
-		l.pos++
-	case l.input[l.pos] == '{':
-		l.currentItem.Token = LeftBraceToken
-		l.currentItem.Value = "{"
-		l.pos++
-	case l.input[l.pos] == '}':
-		l.currentItem.Token = RightBraceToken
-		l.currentItem.Value = "}"
-		l.pos++
-	case l.input[l.pos] == '#':
-		l.currentItem.Token = HashToken
-		l.currentItem.Value = "#"
-		l.pos++
-	case l.input[l.pos] == '*':
-		l.currentItem.Token = WildCard
-		l.currentItem.Value = "*"
-		l.pos++
+	r, size := l.peek()
+	begin := l.pos
 
-	default:
-		if unicode.IsLetter(rune(l.input[l.pos])) {
-			start := l.pos
-			for l.pos < l.length && (unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos])) || l.input[l.pos] == '_') {
-				l.pos++
+	switch {
+	case strings.HasPrefix(l.input[l.pos:], "/*"):
+		// eatSpace stops on an unterminated comment
+		l.currentItem.Token = InvalidToken
+		l.currentItem.Value = "/*"
+		l.pos = l.length
+	case strings.HasPrefix(l.input[l.pos:], "->"):
+		l.currentItem.Token = ArrowToken
+		l.currentItem.Value = "->"
+		l.pos += len("->")
+	case r == '"' || r == '\'':
+		l.readString()
+	case isDigit(r):
+		for r, size = l.peek(); isDigit(r); r, size = l.peek() {
+			l.pos += size
+		}
+		if r == '.' && l.pos+1 < l.length && isDigit(rune(l.input[l.pos+1])) {
+			l.pos++
+			for r, size = l.peek(); isDigit(r); r, size = l.peek() {
+				l.pos += size
 			}
+		}
+		l.currentItem.Token = NumberToken
+		l.currentItem.Value = l.input[begin:l.pos]
+	case isIdentifierStart(r):
+		for r, size = l.peek(); isIdentifierPart(r); r, size = l.peek() {
+			l.pos += size
+		}
+		l.currentItem.Value = l.input[begin:l.pos]
+		if keyword, exists := keywords[l.currentItem.Value]; exists {
+			l.currentItem.Token = keyword
+		} else {
 			l.currentItem.Token = IdentifierToken
-			l.currentItem.Value = l.input[start:l.pos]
+		}
+	default:
+		if punctuation, exists := punctuations[r]; exists {
+			l.currentItem.Token = punctuation
 		} else {
 			l.currentItem.Token = InvalidToken
-			l.currentItem.Value = string(l.input[l.pos])
-			l.pos++
 		}
+		l.currentItem.Value = l.input[begin : begin+size]
+		l.pos += size
 	}
 	return l.currentItem
 }
 
+// a string literal is quoted with " or ' and may contain escaped quotes
+// an unterminated string is an InvalidToken
+func (l *Lexer) readString() {
+	start := l.pos
+	quote := l.input[l.pos]
+	l.pos++
+	for l.pos < l.length && l.input[l.pos] != quote {
+		if l.input[l.pos] == '\\' {
+			l.pos++
+		}
+		l.pos++
+	}
+	if l.pos >= l.length {
+		l.pos = l.length
+		l.currentItem.Token = InvalidToken
+		l.currentItem.Value = l.input[start:l.pos]
+		return
+	}
+	l.pos++
+	l.currentItem.Token = StringToken
+	l.currentItem.Value = l.input[start:l.pos]
+}
+
+// ZCaveats returns the caveats read by ReadZSchema
+func (l *Lexer) ZCaveats() []*ZCaveat {
+	return l.zcaveats
+}
+
+// EndDoc returns the comments written after the last definition or caveat
+func (l *Lexer) EndDoc() string {
+	return l.endDoc
+}
+
+// takeTrailing returns the comments starting after the element just read, on its last line,
+// they are no more in the Doc of the current token
+func (l *Lexer) takeTrailing() string {
+	trailing := l.currentItem.Trailing
+	if trailing != "" {
+		l.currentItem.Doc = strings.TrimPrefix(strings.TrimPrefix(l.currentItem.Doc, trailing), "\n")
+		l.currentItem.Trailing = ""
+	}
+	return trailing
+}
+
+func joinDocs(first string, second string) string {
+	if first == "" || second == "" {
+		return first + second
+	}
+	return first + "\n" + second
+}
+
+// takeInnerDocs appends the comments read inside an element to its doc
+// so that no comment is lost
+func (l *Lexer) takeInnerDocs(doc string) string {
+	docs := l.innerDocs
+	l.innerDocs = nil
+	if doc != "" {
+		docs = append([]string{doc}, docs...)
+	}
+	return strings.Join(docs, "\n")
+}
+
+// describe the current token for the error messages
+// an invalid token is reported with its rune and its code point
+func (item *Item) describe() string {
+	switch {
+	case item.Token == EOFToken:
+		return "end of file"
+	case item.Token != InvalidToken:
+		return "'" + item.Value + "'"
+	case item.Value == "/*":
+		return "unterminated comment"
+	case strings.HasPrefix(item.Value, "\"") || strings.HasPrefix(item.Value, "'"):
+		return "unterminated string"
+	}
+	r, _ := utf8.DecodeRuneInString(item.Value)
+	if r == utf8.RuneError {
+		return fmt.Sprintf("invalid byte %q", item.Value)
+	}
+	return fmt.Sprintf("invalid character '%s' (%U)", item.Value, r)
+}
+
 func (l *Lexer) readAndMatchToken(expected Token) error {
 	if l.currentItem.Token == expected {
 		return nil
 	}
-	return fmt.Errorf("expected token '%v', but got '%v'", TokenToString(expected), l.currentItem.Value)
+	return l.errorf("expected token '%v', but got %s", TokenToString(expected), l.currentItem.describe())
 }
 
 // Syntaxic Analyser
+// Prefix is the optional namespace of the definition, as in prefix/name
 type ZDef struct {
-	Name      string
-	Relations []*ZRelation
-	ID        string
+	Prefix      string
+	Name        string
+	Relations   []*ZRelation
+	Permissions []*ZPermission
+	Span        Span
+	Doc         string
+	EndDoc      string // comments before the closing '}'
 }
 
 type ZRelation struct {
@@ -179,55 +560,286 @@ type ZRelation struct {
 	Zobjects         []*Zobject
 	ZobjectSets      []*ZobjectSet
 	ZobjectWildCards []*ZobjectWildCard
-	ID               string
-	myZDef           *ZDef
+	Span             Span
+	Doc              string
+}
+
+// FullName is prefix/name, or name without prefix
+func (zdef *ZDef) FullName() string {
+	if zdef.Prefix == "" {
+		return zdef.Name
+	}
+	return zdef.Prefix + "/" + zdef.Name
 }
 
-// object
+// splitZName returns the prefix and the name of prefix/name
+func splitZName(fullName string) (string, string) {
+	if index := strings.LastIndex(fullName, "/"); index >= 0 {
+		return fullName[:index], fullName[index+1:]
+	}
+	return "", fullName
+}
+
+// object [with caveat]
+// Name is the name as written, with its prefix if any
 type Zobject struct {
 	Name   string
-	ID     string
-	myZDef *ZDef
-	Unique bool
+	Caveat string
+	Span   Span
 }
 
-// object#relation
+// object#relation [with caveat]
 type ZobjectSet struct {
-	Name       string
-	Relation   string
-	ID         string
-	IDRelation string
-	Unique     bool
+	Name     string
+	Relation string
+	Caveat   string
+	Span     Span
 }
 
-// object:*
+// object:* [with caveat]
 type ZobjectWildCard struct {
 	Name   string
-	ID     string
-	Unique bool
+	Caveat string
+	Span   Span
+}
+
+// caveat name(parameter type, ...) { expression }
+type ZCaveat struct {
+	Name       string
+	Parameters []*ZCaveatParameter
+	Expression string
+	Span       Span
+	Doc        string
+}
+
+type ZCaveatParameter struct {
+	Name string
+	Type string
+	Span Span
+}
+
+// caveat signature as written in the schema
+func (c *ZCaveat) Signature() string {
+	params := []string{}
+	for _, p := range c.Parameters {
+		params = append(params, p.Name+" "+p.Type)
+	}
+	return c.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// permission name = expression
+type ZPermission struct {
+	Name       string
+	Expression ZExpression
+	Span       Span
+	Doc        string
+}
+
+// ZOperator is a binary operator of a permission expression
+type ZOperator int
+
+const (
+	UnionOperator        ZOperator = iota // "+"
+	IntersectionOperator                  // "&"
+	ExclusionOperator                     // "-"
+)
+
+func (o ZOperator) String() string {
+	switch o {
+	case UnionOperator:
+		return "+"
+	case IntersectionOperator:
+		return "&"
+	case ExclusionOperator:
+		return "-"
+	default:
+		return "?"
+	}
+}
+
+// precedence of the operator, the highest binds tighter
+func (o ZOperator) precedence() int {
+	switch o {
+	case UnionOperator:
+		return 3
+	case IntersectionOperator:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// ZExpression is a node of a permission expression tree
+type ZExpression interface {
+	String() string
+}
+
+// a relation or a permission of the same definition
+type ZExprName struct {
+	Name string
+	Span Span
+}
+
+// nil
+type ZExprNil struct {
+}
+
+// relation->name, relation.any(name) or relation.all(name)
+// name is a relation or a permission of every subject type of relation
+type ZExprArrow struct {
+	Relation string
+	Target   string
+	Function string // "" for "->", "any" or "all"
+	Span     Span
+}
+
+// left operator right
+type ZExprBinary struct {
+	Operator ZOperator
+	Left     ZExpression
+	Right    ZExpression
+	Grouped  bool // written between parentheses, kept by String
+}
+
+func (e *ZExprName) String() string {
+	return e.Name
+}
+
+func (e *ZExprNil) String() string {
+	return "nil"
+}
+
+func (e *ZExprArrow) String() string {
+	if e.Function == "" {
+		return e.Relation + "->" + e.Target
+	}
+	return e.Relation + "." + e.Function + "(" + e.Target + ")"
+}
+
+// parentheses are only written when the precedence requires them
+func (e *ZExprBinary) String() string {
+	return zexprText(e, true)
+}
+
+// zexprCanonical prints an expression with the parentheses needed only,
+// two expressions with the same meaning and grouped differently have the same text
+func zexprCanonical(expr ZExpression) string {
+	return zexprText(expr, false)
+}
+
+// zexprText prints an expression with the parentheses needed by the precedence,
+// and with grouped the parentheses written in the schema too
+func zexprText(expr ZExpression, grouped bool) string {
+	e, ok := expr.(*ZExprBinary)
+	if !ok {
+		return expr.String()
+	}
+	left := zexprText(e.Left, grouped)
+	if b, ok := e.Left.(*ZExprBinary); ok && (b.Operator.precedence() < e.Operator.precedence() || grouped && b.Grouped) {
+		left = "(" + left + ")"
+	}
+	right := zexprText(e.Right, grouped)
+	if b, ok := e.Right.(*ZExprBinary); ok && (b.Operator.precedence() <= e.Operator.precedence() || grouped && b.Grouped) {
+		right = "(" + right + ")"
+	}
+	return left + " " + e.Operator.String() + " " + right
+}
+
+// names returns every relation or permission name used by the expression
+func zexprNames(expr ZExpression) []*ZExprName {
+	switch e := expr.(type) {
+	case *ZExprName:
+		return []*ZExprName{e}
+	case *ZExprBinary:
+		return append(zexprNames(e.Left), zexprNames(e.Right)...)
+	default:
+		return nil
+	}
 }
 
-// object#relation
+// arrows returns every arrow used by the expression
+func zexprArrows(expr ZExpression) []*ZExprArrow {
+	switch e := expr.(type) {
+	case *ZExprArrow:
+		return []*ZExprArrow{e}
+	case *ZExprBinary:
+		return append(zexprArrows(e.Left), zexprArrows(e.Right)...)
+	default:
+		return nil
+	}
+}
 
-// <Zschema> ::= <Zdef>*
+// <Zschema> ::= (<Zdef> | <Zcaveat>)*
+// caveats are available with ZCaveats
+// the parser does not stop on the first error : every error is returned in an ErrorList
+// with the definitions and the caveats that could be read
 func (l *Lexer) ReadZSchema() ([]*ZDef, error) {
 	var zdefs []*ZDef
+	l.errors = nil
 
 	for l.currentItem.Token != EOFToken {
+		start := l.currentItem.Span.Start.Offset
+
+		if l.currentItem.Token == CaveatToken {
+			_zcaveat, _err := l.readZCaveat()
+			if _err != nil {
+				l.recover(_err, start, DefinitionToken, CaveatToken)
+				continue
+			}
+			l.NextToken()
+			_zcaveat.Doc = joinDocs(_zcaveat.Doc, l.takeTrailing())
+			l.zcaveats = append(l.zcaveats, &_zcaveat)
+			continue
+		}
+
 		_zdef, _err := l.readZDef()
 		if _err != nil {
-			return zdefs, _err
+			l.recover(_err, start, DefinitionToken, CaveatToken)
+			continue
 		}
-		zdefs = append(zdefs, &_zdef)
 		l.NextToken()
+		_zdef.Doc = joinDocs(_zdef.Doc, l.takeTrailing())
+		zdefs = append(zdefs, &_zdef)
 
 	}
+	l.endDoc = l.takeInnerDocs(l.currentItem.Doc)
+	if len(l.errors) > 0 {
+		return zdefs, l.errors
+	}
 	return zdefs, nil
 }
 
+// recover records err then skips the tokens up to one of the synchronizing tokens (or EOF)
+// the token at start, where the failed rule began, is always skipped so that the parser moves on
+func (l *Lexer) recover(err error, start int, synchronizing ...Token) {
+	syntaxError, ok := err.(*SyntaxError)
+	if !ok {
+		syntaxError = &SyntaxError{Pos: l.currentItem.Span.Start, End: l.currentItem.Span.End, Message: err.Error()}
+	}
+	l.errors = append(l.errors, syntaxError)
+
+	isSynchronizing := func() bool {
+		for _, token := range synchronizing {
+			if l.currentItem.Token == token {
+				return true
+			}
+		}
+		return false
+	}
+
+	if l.currentItem.Span.Start.Offset == start && l.currentItem.Token != EOFToken {
+		l.NextToken()
+	}
+	for l.currentItem.Token != EOFToken && !isSynchronizing() {
+		l.NextToken()
+	}
+}
+
 // <Zdef> ::= "definition" <Zname> "{" <Zbody> "}"
 func (l *Lexer) readZDef() (ZDef, error) {
 	var zdef ZDef
+	start := l.currentItem.Span.Start
+	zdef.Doc = l.currentItem.Doc
 
 	// read "definition"
 	err := l.readAndMatchToken(DefinitionToken)
@@ -237,12 +849,11 @@ func (l *Lexer) readZDef() (ZDef, error) {
 	l.NextToken()
 
 	// read <Zname>
-	err = l.readAndMatchToken(IdentifierToken)
+	_name, err := l.readZName()
 	if err != nil {
 		return zdef, err
 	}
-	zdef.Name = l.currentItem.Value
-	l.NextToken()
+	zdef.Prefix, zdef.Name = splitZName(_name)
 
 	// read '{'
 	err = l.readAndMatchToken(LeftBraceToken)
@@ -251,49 +862,74 @@ func (l *Lexer) readZDef() (ZDef, error) {
 	}
 	l.NextToken()
 
+	zdef.Doc = l.takeInnerDocs(zdef.Doc)
+
 	// read ZBody
 	// ZBody is not a token
 	// no need to call NextToken after
 
-	zdef, err = l.readZBody(zdef)
-	if err != nil {
-		return zdef, err
-	}
+	zdef = l.readZBody(zdef)
 
 	// read '}'
 	err = l.readAndMatchToken(RightBraceToken)
 	if err != nil {
 		return zdef, err
 	}
+	zdef.Span = Span{Start: start, End: l.currentItem.Span.End}
+	zdef.EndDoc = l.currentItem.Doc
 
 	return zdef, nil
 }
 
-// <Zbody> ::= <Zrelation>*
-// * means zero or more <Zrelation>
-func (l *Lexer) readZBody(zdef ZDef) (ZDef, error) {
+// <Zbody> ::= (<Zrelation> | <Zpermission>)*
+// * means zero or more <Zrelation> or <Zpermission>
+// a wrong relation or permission is recorded and skipped up to the next one
+// the body stops on '}' or on a token which cannot be in a body
+func (l *Lexer) readZBody(zdef ZDef) ZDef {
 	// var zdef ZDef
 
-	for l.currentItem.Token == RelationToken {
+	synchronizing := []Token{RelationToken, PermissionToken, RightBraceToken, DefinitionToken, CaveatToken}
 
-		relation, err := l.readZRelation()
-		if err != nil {
-			return zdef, err
+	for {
+		start := l.currentItem.Span.Start.Offset
+
+		switch l.currentItem.Token {
+		case RelationToken:
+			relation, err := l.readZRelation()
+			if err != nil {
+				l.recover(err, start, synchronizing...)
+				continue
+			}
+			zdef.Relations = append(zdef.Relations, &relation)
+
+		case PermissionToken:
+			permission, err := l.readZPermission()
+			if err != nil {
+				l.recover(err, start, synchronizing...)
+				continue
+			}
+			zdef.Permissions = append(zdef.Permissions, &permission)
+
+		case RightBraceToken, DefinitionToken, CaveatToken, EOFToken:
+			return zdef
+
+		default:
+			err := l.errorf("expected 'relation', 'permission' or '}', but got %s", l.currentItem.describe())
+			l.recover(err, start, synchronizing...)
 		}
-		zdef.Relations = append(zdef.Relations, &relation)
 	}
-
-	return zdef, nil
 }
 
 // <Zrelation> ::= "relation" <Rname> ":" <Sname> ("|" <Sname)*
-// <Sname> ::= <Zname> | <Zname> "#" <Rname> | <Zname> ":" "*"
+// <Sname> ::= (<Zname> | <Zname> "#" <Rname> | <Zname> ":" "*") ["with" <Cname>]
 
 func (l *Lexer) readZRelation() (ZRelation, error) {
 	var zrelation ZRelation
+	start := l.currentItem.Span.Start
+	zrelation.Doc = l.currentItem.Doc
 
 	if l.currentItem.Value != "relation" {
-		return zrelation, fmt.Errorf("expected 'relation', but got '%s'", l.currentItem.Value)
+		return zrelation, l.errorf("expected 'relation', but got %s", l.currentItem.describe())
 	}
 	l.NextToken()
 
@@ -311,8 +947,11 @@ func (l *Lexer) readZRelation() (ZRelation, error) {
 	l.NextToken()
 
 	for l.currentItem.Token == IdentifierToken {
-		_name := l.currentItem.Value
-		l.NextToken()
+		_start := l.currentItem.Span.Start
+		_name, err := l.readZName()
+		if err != nil {
+			return zrelation, err
+		}
 
 		//  <Sname> ::= <Zname> "#" <Rname>
 		if l.currentItem.Token == HashToken {
@@ -321,8 +960,14 @@ func (l *Lexer) readZRelation() (ZRelation, error) {
 			if err != nil {
 				return zrelation, err
 			} else {
-				zrelation.ZobjectSets = append(zrelation.ZobjectSets, &ZobjectSet{Name: _name, Relation: l.currentItem.Value})
+				zobjectSet := &ZobjectSet{Name: _name, Relation: l.currentItem.Value}
+				zrelation.ZobjectSets = append(zrelation.ZobjectSets, zobjectSet)
 				l.NextToken()
+				zobjectSet.Caveat, err = l.readWithCaveat()
+				if err != nil {
+					return zrelation, err
+				}
+				zobjectSet.Span = Span{Start: _start, End: l.prevEnd}
 			}
 
 		} else {
@@ -334,11 +979,23 @@ func (l *Lexer) readZRelation() (ZRelation, error) {
 					return zrelation, err
 				} else {
 					// zrelation.ZobjectAll =
-					zrelation.ZobjectWildCards = append(zrelation.ZobjectWildCards, &ZobjectWildCard{Name: _name})
+					zobjectWildCard := &ZobjectWildCard{Name: _name}
+					zrelation.ZobjectWildCards = append(zrelation.ZobjectWildCards, zobjectWildCard)
 					l.NextToken()
+					zobjectWildCard.Caveat, err = l.readWithCaveat()
+					if err != nil {
+						return zrelation, err
+					}
+					zobjectWildCard.Span = Span{Start: _start, End: l.prevEnd}
 				}
 			} else { // <Sname> ::= <Zname>
-				zrelation.Zobjects = append(zrelation.Zobjects, &Zobject{Name: _name})
+				zobject := &Zobject{Name: _name}
+				zrelation.Zobjects = append(zrelation.Zobjects, zobject)
+				zobject.Caveat, err = l.readWithCaveat()
+				if err != nil {
+					return zrelation, err
+				}
+				zobject.Span = Span{Start: _start, End: l.prevEnd}
 			}
 		}
 
@@ -349,348 +1006,798 @@ func (l *Lexer) readZRelation() (ZRelation, error) {
 			// it's the last
 		}
 	}
+	zrelation.Span = Span{Start: start, End: l.prevEnd}
+	zrelation.Doc = joinDocs(l.takeInnerDocs(zrelation.Doc), l.takeTrailing())
 
 	return zrelation, nil
 }
 
-// Generation Code
-
-type PlantUMLArchimateSchema struct {
-	Zdefs   []*ZDef
-	ZdefMap map[string]*ZDef
+// <Zname> ::= [<Zprefix> "/"] <identifier>
+// <Zprefix> ::= <identifier> ("/" <identifier>)*
+// returns prefix/name or name
+func (l *Lexer) readZName() (string, error) {
+	err := l.readAndMatchToken(IdentifierToken)
+	if err != nil {
+		return "", err
+	}
+	_name := l.currentItem.Value
+	l.NextToken()
 
-	SchemaDpi   int
-	SchemaScale float64
+	for l.currentItem.Token == SlashToken {
+		l.NextToken()
+		err = l.readAndMatchToken(IdentifierToken)
+		if err != nil {
+			return "", err
+		}
+		_name = _name + "/" + l.currentItem.Value
+		l.NextToken()
+	}
+	return _name, nil
 }
 
-// Generate a row for each businessObject
-/*
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) generateRowForEachBusinessObject(out []string) {
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		line := fmt.Sprintf("Business_Object(%s,\"%s\")", zdef.ID, zdef.Name)
-		out = append(&out, line)
+// ["with" <Cname>]
+// returns the caveat name or "" without caveat
+func (l *Lexer) readWithCaveat() (string, error) {
+	if l.currentItem.Token != WithToken {
+		return "", nil
+	}
+	l.NextToken()
+	err := l.readAndMatchToken(IdentifierToken)
+	if err != nil {
+		return "", err
 	}
+	_name := l.currentItem.Value
+	l.NextToken()
+	return _name, nil
 }
-*/
-
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) Generate(pngfilename string) string {
-	var out []string
-	plantUMLArchimateSchema.createIDforZdef()
-
-	out = append(out, "@startuml "+pngfilename)
-	out = append(out, "!include <archimate/Archimate>")
-
-	out = append(out, "scale 1.0")
-	out = append(out, "skinparam dpi 96")
 
-	// Generate a row for each businessObject
+// <Zcaveat> ::= "caveat" <Cname> "(" <Cparam> ("," <Cparam>)* ")" "{" <Cexpression> "}"
+func (l *Lexer) readZCaveat() (ZCaveat, error) {
+	var zcaveat ZCaveat
+	start := l.currentItem.Span.Start
+	zcaveat.Doc = l.currentItem.Doc
 
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		line := fmt.Sprintf("Business_Object(%s,\"%s\")", zdef.ID, zdef.Name)
-		out = append(out, line)
+	err := l.readAndMatchToken(CaveatToken)
+	if err != nil {
+		return zcaveat, err
 	}
+	l.NextToken()
 
-	// Generate a relationship line as a business object for each zdef
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			switch zrel.ID {
-			case "NOTDRAW":
-				line := fmt.Sprintf("rectangle \"relation %s is duplicated in definition %s \" #red", zrel.Name, zdef.Name)
-				out = append(out, line)
-			default:
-				line := fmt.Sprintf("Business_Object(%s,\"%s\") <<relation>>", zrel.ID, zrel.Name)
-				line2 := fmt.Sprintf("Rel_Association(%s,%s)", zdef.ID, zrel.ID)
-				out = append(out, line)
-				out = append(out, line2)
-				for _, zobject := range zrel.Zobjects {
-					switch zobject.ID {
-					case "NOTDRAW":
-						line3 := fmt.Sprintf("rectangle \"definition %s does not exist \" #red", zobject.Name)
-						out = append(out, line3)
-					default:
-						switch zobject.Unique {
-						case true:
-							line4 := fmt.Sprintf("Rel_Access_w(%s,%s)", zrel.ID, zobject.ID)
-							out = append(out, line4)
-						default:
-							line4 := fmt.Sprintf("rectangle \" %s is declared more that one in relation %s of definition %s\" #red ", zdef.Name, zrel.Name, zdef.Name)
-							out = append(out, line4)
-						}
-					}
-				}
-			}
-		}
+	err = l.readAndMatchToken(IdentifierToken)
+	if err != nil {
+		return zcaveat, err
 	}
+	zcaveat.Name = l.currentItem.Value
+	l.NextToken()
 
-	// Generate a relationshipSet row on a relation
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			for _, zobjectSet := range zrel.ZobjectSets {
-				switch zobjectSet.ID {
-				case "NOTDRAW":
-					line := fmt.Sprintf("rectangle \"definition %s does not exist in \" #red", zobjectSet.Name)
-					out = append(out, line)
-				default:
-					switch zobjectSet.IDRelation {
-					case "NOTDRAW":
-						line := fmt.Sprintf("rectangle \"  %s#%s in definition %s  : relation %s does not exist in %s \"  #red", zobjectSet.Name, zobjectSet.Relation, zdef.Name, zobjectSet.Relation, zobjectSet.Name)
-						out = append(out, line)
-					default:
-						switch zobjectSet.Unique {
-						case true:
-							line2 := fmt.Sprintf("Rel_Access_w(%s,%s,\"%s#%s\")", zobjectSet.IDRelation, zrel.ID, zobjectSet.Name, zobjectSet.Relation)
-							out = append(out, line2)
-						case false:
-							line2 := fmt.Sprintf("rectangle \"  %s#%s declared more that one in relation %s of definition %s \"  #red", zobjectSet.Name, zobjectSet.Relation, zrel.Name, zdef.Name)
-							out = append(out, line2)
-						}
-					}
-				}
-			}
+	err = l.readAndMatchToken(LeftParenToken)
+	if err != nil {
+		return zcaveat, err
+	}
+	l.NextToken()
+
+	for {
+		// <Cparam> ::= <identifier> <Ctype>
+		err = l.readAndMatchToken(IdentifierToken)
+		if err != nil {
+			return zcaveat, err
+		}
+		param := &ZCaveatParameter{Name: l.currentItem.Value}
+		_start := l.currentItem.Span.Start
+		l.NextToken()
+		param.Type, err = l.readZCaveatType()
+		if err != nil {
+			return zcaveat, err
 		}
+		param.Span = Span{Start: _start, End: l.prevEnd}
+		zcaveat.Parameters = append(zcaveat.Parameters, param)
+
+		if l.currentItem.Token != CommaToken {
+			break
+		}
+		l.NextToken()
 	}
 
-	// Generate a relationWildCard row on a relation
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			for _, zobjectWildCard := range zrel.ZobjectWildCards {
-				switch zobjectWildCard.ID {
-				case "NOTDRAW":
-					line := fmt.Sprintf("rectangle \"definition %s does not exist in \" #red", zobjectWildCard.Name)
-					out = append(out, line)
-				default:
-					switch zobjectWildCard.Unique {
+	err = l.readAndMatchToken(RightParenToken)
+	if err != nil {
+		return zcaveat, err
+	}
+	l.NextToken()
 
-					case true:
-						line2 := fmt.Sprintf("Rel_Access_w(%s,%s,\"%s\")", zrel.ID, zobjectWildCard.ID, "ALL")
-						out = append(out, line2)
+	err = l.readAndMatchToken(LeftBraceToken)
+	if err != nil {
+		return zcaveat, err
+	}
 
-					case false:
-						line3 := fmt.Sprintf("rectangle \"wildcard  %s:* is declared more than one in relation %s of definition %s\" #red", zobjectWildCard.Name, zrel.Name, zdef.Name)
-						out = append(out, line3)
-					}
+	// <Cexpression> is kept as written, braces must be balanced
+	zcaveat.Doc = l.takeInnerDocs(zcaveat.Doc)
 
-				}
-			}
+	expressionStart := l.pos
+	depth := 1
+	for depth > 0 {
+		l.NextToken()
+		switch l.currentItem.Token {
+		case LeftBraceToken:
+			depth++
+		case RightBraceToken:
+			depth--
+		case EOFToken:
+			return zcaveat, l.errorf("expected token '%v', but got %s", TokenToString(RightBraceToken), l.currentItem.describe())
 		}
 	}
+	// the current token is the closing '}'
+	zcaveat.Expression = strings.TrimSpace(l.input[expressionStart : l.pos-1])
+	// the comments of the expression are kept in the expression
+	l.takeInnerDocs("")
+	zcaveat.Span = Span{Start: start, End: l.currentItem.Span.End}
 
-	out = append(out, "@enduml")
-	return strings.Join(out, "\n")
+	return zcaveat, nil
 }
 
-// utility
-func contains(slice []string, item string) bool {
-	for _, v := range slice {
-		if v == item {
-			return true
+// <Ctype> ::= <identifier> ["<" <Ctype> ">"]
+func (l *Lexer) readZCaveatType() (string, error) {
+	err := l.readAndMatchToken(IdentifierToken)
+	if err != nil {
+		return "", err
+	}
+	_type := l.currentItem.Value
+	l.NextToken()
+
+	if l.currentItem.Token == LessToken {
+		l.NextToken()
+		_subtype, err := l.readZCaveatType()
+		if err != nil {
+			return "", err
+		}
+		err = l.readAndMatchToken(GreaterToken)
+		if err != nil {
+			return "", err
 		}
+		l.NextToken()
+		_type = _type + "<" + _subtype + ">"
 	}
-	return false
+	return _type, nil
 }
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) createIDforZdef() {
-	zdefMapNameToVarName := make(map[string]string)
+// <Zpermission> ::= "permission" <Pname> "=" <Zexclusion>
+func (l *Lexer) readZPermission() (ZPermission, error) {
+	var zpermission ZPermission
+	start := l.currentItem.Span.Start
+	zpermission.Doc = l.currentItem.Doc
 
-	for index, zdef := range plantUMLArchimateSchema.Zdefs {
-		varname := fmt.Sprintf("b%d", index+1)
-		if _, exists := zdefMapNameToVarName[zdef.Name]; exists {
-			fmt.Printf("definition %s is declared more that one  \n", zdef.Name)
-			continue
-		}
-		zdefMapNameToVarName[zdef.Name] = varname
-		zdef.ID = varname
+	if l.currentItem.Value != "permission" {
+		return zpermission, l.errorf("expected 'permission', but got %s", l.currentItem.describe())
 	}
+	l.NextToken()
 
-	plantUMLArchimateSchema.createIDforZdefRelations()
-	plantUMLArchimateSchema.initZdefMap()
-	plantUMLArchimateSchema.verifyAndAssignIDforZobjectInRelations()
-	plantUMLArchimateSchema.verifyUniqueObjectForEachRelation()
-	plantUMLArchimateSchema.verifyAndAssignIDInRelationsforZobjectSet()
-	plantUMLArchimateSchema.verifyUniqueSetObjectForEachRelation()
-	plantUMLArchimateSchema.verifyAndAssignIDInRelationsforZobjectWildCard()
-	plantUMLArchimateSchema.verifyUniqueObjectWildCardForEachRelation()
-}
+	err := l.readAndMatchToken(IdentifierToken)
+	if err != nil {
+		return zpermission, err
+	}
+	zpermission.Name = l.currentItem.Value
+	l.NextToken()
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) createIDforZdefRelations() {
-	var relCount int = 0
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		RelNameSlice := []string{}
-		for _, zrel := range zdef.Relations {
-			relCount++
-			varname := fmt.Sprintf("r%d", relCount)
-			if contains(RelNameSlice, zrel.Name) {
-				zrel.ID = "NOTDRAW"
-				fmt.Printf("relation %s is declared more that one in definition %s \n", zrel.Name, zdef.Name)
+	err = l.readAndMatchToken(EqualToken)
+	if err != nil {
+		return zpermission, err
+	}
+	l.NextToken()
 
-			} else {
-				RelNameSlice = append(RelNameSlice, zrel.Name)
-				zrel.ID = varname
-				zrel.myZDef = zdef
-			}
-		}
+	zpermission.Expression, err = l.readZExclusion()
+	if err != nil {
+		return zpermission, err
 	}
+	zpermission.Span = Span{Start: start, End: l.prevEnd}
+	zpermission.Doc = joinDocs(l.takeInnerDocs(zpermission.Doc), l.takeTrailing())
 
+	return zpermission, nil
 }
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) initZdefMap() {
-	plantUMLArchimateSchema.ZdefMap = make(map[string]*ZDef)
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		plantUMLArchimateSchema.ZdefMap[zdef.Name] = zdef
+// <Zexclusion> ::= <Zintersection> ("-" <Zintersection>)*
+func (l *Lexer) readZExclusion() (ZExpression, error) {
+	left, err := l.readZIntersection()
+	if err != nil {
+		return nil, err
 	}
-
+	for l.currentItem.Token == MinusToken {
+		l.NextToken()
+		right, err := l.readZIntersection()
+		if err != nil {
+			return nil, err
+		}
+		left = &ZExprBinary{Operator: ExclusionOperator, Left: left, Right: right}
+	}
+	return left, nil
 }
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) verifyAndAssignIDforZobjectInRelations() {
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			for _, zobject := range zrel.Zobjects {
-				if myZDef, exists := plantUMLArchimateSchema.ZdefMap[zobject.Name]; exists {
-					zobject.ID = myZDef.ID
-					zobject.myZDef = myZDef
-				} else {
-					fmt.Printf("%s declared in relation %s of definition %s does not exist.  \n", zobject.Name, zrel.Name, zdef.Name)
-					zobject.ID = "NOTDRAW"
-				}
-
-			}
+// <Zintersection> ::= <Zunion> ("&" <Zunion>)*
+func (l *Lexer) readZIntersection() (ZExpression, error) {
+	left, err := l.readZUnion()
+	if err != nil {
+		return nil, err
+	}
+	for l.currentItem.Token == AmpersandToken {
+		l.NextToken()
+		right, err := l.readZUnion()
+		if err != nil {
+			return nil, err
 		}
+		left = &ZExprBinary{Operator: IntersectionOperator, Left: left, Right: right}
 	}
+	return left, nil
 }
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) findZDef(objectName string) (*ZDef, error) {
-	if myZDef, exists := plantUMLArchimateSchema.ZdefMap[objectName]; exists {
-		return myZDef, nil
-	} else {
-		err := fmt.Errorf("definition %s does not exist. ", objectName)
+// <Zunion> ::= <Zterm> ("+" <Zterm>)*
+func (l *Lexer) readZUnion() (ZExpression, error) {
+	left, err := l.readZTerm()
+	if err != nil {
 		return nil, err
 	}
+	for l.currentItem.Token == PlusToken {
+		l.NextToken()
+		right, err := l.readZTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &ZExprBinary{Operator: UnionOperator, Left: left, Right: right}
+	}
+	return left, nil
+}
 
+// <Zterm> ::= <Zarrow> | <identifier> | "nil" | "(" <Zexclusion> ")"
+func (l *Lexer) readZTerm() (ZExpression, error) {
+	switch l.currentItem.Token {
+	case IdentifierToken:
+		_name := l.currentItem.Value
+		_start := l.currentItem.Span.Start
+		l.NextToken()
+		if l.currentItem.Token == ArrowToken || l.currentItem.Token == DotToken {
+			return l.readZArrow(_name, _start)
+		}
+		return &ZExprName{Name: _name, Span: Span{Start: _start, End: l.prevEnd}}, nil
+	case NilToken:
+		l.NextToken()
+		return &ZExprNil{}, nil
+	case LeftParenToken:
+		l.NextToken()
+		expr, err := l.readZExclusion()
+		if err != nil {
+			return nil, err
+		}
+		err = l.readAndMatchToken(RightParenToken)
+		if err != nil {
+			return nil, err
+		}
+		l.NextToken()
+		if b, ok := expr.(*ZExprBinary); ok {
+			b.Grouped = true
+		}
+		return expr, nil
+	default:
+		return nil, l.errorf("expected a relation, a permission, 'nil' or '(', but got %s", l.currentItem.describe())
+	}
 }
 
-// with tuple example like object:id#relation1@objectSet#relation2 (with Zanzibar notation)
-// objectName is objectSet, relationName is relation2
+// <Zarrow> ::= <Rname> "->" <identifier> | <Rname> "." <Afunction> "(" <identifier> ")"
+// <Rname> is already read at start
+func (l *Lexer) readZArrow(relation string, start Position) (ZExpression, error) {
+	arrow := &ZExprArrow{Relation: relation}
+	defer func() {
+		arrow.Span = Span{Start: start, End: l.prevEnd}
+	}()
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) findZRelation(objectName string, relationName string) (*ZRelation, error) {
-	myZf, err := plantUMLArchimateSchema.findZDef(objectName)
-	if err != nil {
-		return nil, err
-	} else {
-		zrelMap := make(map[string]*ZRelation)
-		for _, zrel := range myZf.Relations {
-			zrelMap[zrel.Name] = zrel
+	if l.currentItem.Token == DotToken {
+		l.NextToken()
+		err := l.readAndMatchToken(IdentifierToken)
+		if err != nil {
+			return nil, err
 		}
-		if myZrel, exists := zrelMap[relationName]; exists {
-			return myZrel, nil
-		} else {
-			err = fmt.Errorf("relation %s does not exist in definition %s. ", relationName, objectName)
+		if l.currentItem.Value != "any" && l.currentItem.Value != "all" {
+			return nil, l.errorf("expected 'any' or 'all', but got %s", l.currentItem.describe())
+		}
+		arrow.Function = l.currentItem.Value
+		l.NextToken()
+
+		err = l.readAndMatchToken(LeftParenToken)
+		if err != nil {
+			return nil, err
+		}
+		l.NextToken()
+
+		err = l.readAndMatchToken(IdentifierToken)
+		if err != nil {
+			return nil, err
+		}
+		arrow.Target = l.currentItem.Value
+		l.NextToken()
+
+		err = l.readAndMatchToken(RightParenToken)
+		if err != nil {
 			return nil, err
 		}
+		l.NextToken()
+		return arrow, nil
 	}
 
+	// "->"
+	l.NextToken()
+	err := l.readAndMatchToken(IdentifierToken)
+	if err != nil {
+		return nil, err
+	}
+	arrow.Target = l.currentItem.Value
+	l.NextToken()
+	return arrow, nil
 }
 
-// tuple like resource:id#relation@group#relation (with Zanzibar notation)
-// group#relation must exist
+// Generation Code
+
+type PlantUMLArchimateSchema struct {
+	Zdefs    []*ZDef
+	Zcaveats []*ZCaveat
+	Schema   *Schema // compiled from Zdefs and Zcaveats when nil
+
+	SchemaDpi   int
+	SchemaScale float64
+
+	HideDocs bool // do not draw the Doc comments as notes
+
+	Options RenderOptions // direction, title, legend, colors and skinparams
+
+	Links map[string]string // url of the definitions drawn with a link, by name
+
+	ids map[interface{}]string // PlantUML variable of each element of Schema
+}
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) verifyAndAssignIDInRelationsforZobjectSet() {
+// Generate a row for each businessObject
+/*
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) generateRowForEachBusinessObject(out []string) {
 	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			for _, zobjectSet := range zrel.ZobjectSets {
-				if myZDef, exists := plantUMLArchimateSchema.ZdefMap[zobjectSet.Name]; exists {
-					zobjectSet.ID = myZDef.ID
-					myZel, error := plantUMLArchimateSchema.findZRelation(myZDef.Name, zobjectSet.Relation)
-					if error != nil {
-						zobjectSet.IDRelation = "NOTDRAW"
-						fmt.Printf("relation %s declared in %s does not exist in  %s.  \n", zobjectSet.Relation, zdef.Name, myZDef.Name)
-
-					} else {
-						//double ?
-						zobjectSet.IDRelation = myZel.ID
-					}
+		line := fmt.Sprintf("Business_Object(%s,\"%s\")", zdef.ID, zdef.Name)
+		out = append(&out, line)
+	}
+}
+*/
 
-				} else {
-					fmt.Printf("%s declared in %s does not exist.  \n", zobjectSet.Name, zdef.Name)
-					zobjectSet.ID = "NOTDRAW"
-				}
+type plantUMLRenderer struct{}
 
-			}
+func init() {
+	RegisterRenderer(plantUMLRenderer{})
+}
+
+func (plantUMLRenderer) Name() string {
+	return "plantuml"
+}
+
+func (plantUMLRenderer) Extension() string {
+	return ".puml"
+}
+
+func (plantUMLRenderer) Render(schema *Schema, options RenderOptions) string {
+	mydraw := PlantUMLArchimateSchema{Schema: options.schema(schema), SchemaDpi: options.Dpi, SchemaScale: options.Scale, HideDocs: options.HideDocs, Options: options}
+	return mydraw.Generate(options.Name)
+}
+
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) Generate(pngfilename string) string {
+	var out []string
+	plantUMLArchimateSchema.createIDforZdef()
+	schema := plantUMLArchimateSchema.Schema
+	id := plantUMLArchimateSchema.id
+	options := plantUMLArchimateSchema.Options
+	red := plantUMLColor(options.errorColor("red"))
+
+	out = plantUMLArchimateSchema.appendHeader(out, pngfilename)
+
+	// Generate a row for each businessObject
+	out = plantUMLArchimateSchema.appendDefinitions(out)
+
+	// Generate a row for each caveat as a constraint
+
+	for _, caveat := range schema.Caveats {
+		if caveat.Duplicate {
+			line := fmt.Sprintf("rectangle \"caveat %s is declared more than one \" %s", caveat.Name(), red)
+			out = append(out, line)
+		} else {
+			line := fmt.Sprintf("Motivation_Constraint(%s,\"%s\")", id(caveat), caveat.Source.Signature())
+			out = append(out, line)
+			out = plantUMLArchimateSchema.appendNote(out, id(caveat), caveat.Source.Doc)
 		}
 	}
-}
 
-// tuple like resource:id#relation@user:* (with Zanzibar notation)
+	// the members of a duplicated definition are not drawn
+	definitions := []*Definition{}
+	for _, d := range schema.Definitions {
+		if !d.Duplicate {
+			definitions = append(definitions, d)
+		}
+	}
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) verifyAndAssignIDInRelationsforZobjectWildCard() {
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			for _, zobjectWildCard := range zrel.ZobjectWildCards {
-				if myZDef, exists := plantUMLArchimateSchema.ZdefMap[zobjectWildCard.Name]; exists {
-					zobjectWildCard.ID = myZDef.ID
+	// Generate a relationship line as a business object for each zdef
+	for _, d := range definitions {
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				line := fmt.Sprintf("rectangle \"relation %s is duplicated in definition %s \" %s", r.Name(), d.Name(), red)
+				out = append(out, line)
+				continue
+			}
+			line := fmt.Sprintf("Business_Object(%s,\"%s\") <<relation>>", id(r), r.Name())
+			line2 := fmt.Sprintf("Rel_Association(%s,%s)", id(d), id(r))
+			out = append(out, line)
+			out = append(out, line2)
+			out = plantUMLArchimateSchema.appendNote(out, id(r), r.Source.Doc)
+			for _, s := range subjectsOfKind(r, ObjectSubject) {
+				switch {
+				case s.Target == nil:
+					line3 := fmt.Sprintf("rectangle \"definition %s does not exist%s \" %s", s.Name, DidYouMean(schema.SuggestDefinitions(s.Name, d)), red)
+					out = append(out, line3)
+				case s.Duplicate:
+					line4 := fmt.Sprintf("rectangle \" %s is declared more that one in relation %s of definition %s\" %s ", s.Name, r.Name(), d.Name(), red)
+					out = append(out, line4)
+				case s.CaveatName != "":
+					line4 := fmt.Sprintf("Rel_Access_w(%s,%s,\"%s\")", id(r), id(s.Target), withCaveat("", s.CaveatName))
+					out = append(out, line4)
+				default:
+					line4 := fmt.Sprintf("Rel_Access_w(%s,%s)", id(r), id(s.Target))
+					out = append(out, line4)
+				}
+			}
+		}
+	}
 
-				} else {
-					fmt.Printf("%s declared in relation %s of definition %s does not exist.  \n", zobjectWildCard.Name, zrel.Name, zdef.Name)
-					zobjectWildCard.ID = "NOTDRAW"
+	// Generate a relationshipSet row on a relation
+	for _, d := range definitions {
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				continue
+			}
+			for _, s := range subjectsOfKind(r, SubjectSet) {
+				switch {
+				case s.Target == nil:
+					line := fmt.Sprintf("rectangle \"definition %s does not exist%s \" %s", s.Name, DidYouMean(schema.SuggestDefinitions(s.Name, d)), red)
+					out = append(out, line)
+				case s.TargetRelation == nil:
+					line := fmt.Sprintf("rectangle \"  %s in definition %s  : relation %s does not exist in %s%s \"  %s", s.Label(), d.Name(), s.RelationName, s.Name, DidYouMean(s.Target.SuggestRelations(s.RelationName)), red)
+					out = append(out, line)
+				case s.Duplicate:
+					line2 := fmt.Sprintf("rectangle \"  %s declared more that one in relation %s of definition %s \"  %s", s.Label(), r.Name(), d.Name(), red)
+					out = append(out, line2)
+				default:
+					line2 := fmt.Sprintf("Rel_Access_w(%s,%s,\"%s\")", id(s.TargetRelation), id(r), withCaveat(s.Label(), s.CaveatName))
+					out = append(out, line2)
 				}
 			}
 		}
 	}
-}
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) verifyUniqueObjectForEachRelation() {
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			keyObjectSlice := []string{}
-			for _, zobject := range zrel.Zobjects {
-				varname := zobject.Name
-				if contains(keyObjectSlice, varname) {
-					zobject.Unique = false
-					fmt.Printf("%s is declared more that one in relation %s of definition %s\n", varname, zrel.Name, zdef.Name)
-				} else {
-					zobject.Unique = true
-					keyObjectSlice = append(keyObjectSlice, varname)
+	// Generate a permission line as a business object for each zdef
+	for _, d := range definitions {
+		for _, p := range d.Permissions {
+			if p.Duplicate {
+				line := fmt.Sprintf("rectangle \"permission %s is duplicated in definition %s \" %s", p.Name(), d.Name(), red)
+				out = append(out, line)
+				continue
+			}
+			line := fmt.Sprintf("Business_Object(%s,\"%s\\n= %s\") <<permission>>", id(p), p.Name(), p.Source.Expression.String())
+			line2 := fmt.Sprintf("Rel_Association(%s,%s)", id(d), id(p))
+			out = append(out, line)
+			out = append(out, line2)
+			out = plantUMLArchimateSchema.appendNote(out, id(p), p.Source.Doc)
+			// a name used twice by the expression is drawn once
+			drawn := []string{}
+			for _, ref := range p.References {
+				if contains(drawn, ref.Source.Name) {
+					continue
+				}
+				drawn = append(drawn, ref.Source.Name)
+				switch {
+				case ref.Relation != nil:
+					out = append(out, fmt.Sprintf("Rel_Aggregation(%s,%s)", id(p), id(ref.Relation)))
+				case ref.Permission != nil:
+					out = append(out, fmt.Sprintf("Rel_Aggregation(%s,%s)", id(p), id(ref.Permission)))
+				default:
+					line3 := fmt.Sprintf("rectangle \"%s used by permission %s does not exist in definition %s%s \" %s", ref.Source.Name, p.Name(), d.Name(), DidYouMean(d.SuggestMembers(ref.Source.Name)), red)
+					out = append(out, line3)
+				}
+			}
+			for _, arrow := range p.Arrows {
+				if contains(drawn, arrow.Source.String()) {
+					continue
+				}
+				drawn = append(drawn, arrow.Source.String())
+				if arrow.Relation == nil {
+					line3 := fmt.Sprintf("rectangle \"%s used by permission %s is not a relation of definition %s%s \" %s", arrow.Source.Relation, p.Name(), d.Name(), DidYouMean(d.SuggestRelations(arrow.Source.Relation)), red)
+					out = append(out, line3)
+					continue
+				}
+				line3 := fmt.Sprintf("Rel_Aggregation(%s,%s,\"%s\")", id(p), id(arrow.Relation), arrow.Source.String())
+				out = append(out, line3)
+				for _, missing := range arrow.MissingIn {
+					line4 := fmt.Sprintf("rectangle \"%s used by permission %s of definition %s does not exist in %s%s \" %s", arrow.Source.Target, p.Name(), d.Name(), missing.Name(), DidYouMean(missing.SuggestMembers(arrow.Source.Target)), red)
+					out = append(out, line4)
 				}
 			}
 		}
 	}
-}
 
-// 'objectName#relationName' must be unique in zrel
+	// Generate a relationWildCard row on a relation
+	for _, d := range definitions {
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				continue
+			}
+			for _, s := range subjectsOfKind(r, WildcardSubject) {
+				switch {
+				case s.Target == nil:
+					line := fmt.Sprintf("rectangle \"definition %s does not exist%s \" %s", s.Name, DidYouMean(schema.SuggestDefinitions(s.Name, d)), red)
+					out = append(out, line)
+				case s.Duplicate:
+					line3 := fmt.Sprintf("rectangle \"wildcard  %s is declared more than one in relation %s of definition %s\" %s", s.Label(), r.Name(), d.Name(), red)
+					out = append(out, line3)
+				case options.WildcardColor != "":
+					line2 := fmt.Sprintf("%s .[%s].> %s : \"%s\"", id(r), plantUMLColor(options.WildcardColor), id(s.Target), withCaveat("ALL", s.CaveatName))
+					out = append(out, line2)
+				default:
+					line2 := fmt.Sprintf("Rel_Access_w(%s,%s,\"%s\")", id(r), id(s.Target), withCaveat("ALL", s.CaveatName))
+					out = append(out, line2)
+				}
+			}
+		}
+	}
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) verifyUniqueSetObjectForEachRelation() {
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			keySetObjectSlice := []string{}
-			for _, zobjectSet := range zrel.ZobjectSets {
-				varname := fmt.Sprintf("%s#%s", zobjectSet.Name, zobjectSet.Relation)
-				if contains(keySetObjectSlice, varname) {
-					zobjectSet.Unique = false
-					fmt.Printf("%s is declared more that one in relation %s of definition %s\n", varname, zrel.Name, zdef.Name)
-				} else {
-					zobjectSet.Unique = true
-					keySetObjectSlice = append(keySetObjectSlice, varname)
+	// Generate a caveat association on a relation
+	for _, d := range definitions {
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				continue
+			}
+			drawn := []string{}
+			for _, s := range orderedSubjects(r) {
+				switch {
+				case s.CaveatName == "":
+					// no caveat
+				case s.Caveat == nil:
+					line := fmt.Sprintf("rectangle \"caveat %s used in relation %s of definition %s does not exist%s \" %s", s.CaveatName, r.Name(), d.Name(), DidYouMean(schema.SuggestCaveats(s.CaveatName)), red)
+					out = append(out, line)
+				case !contains(drawn, id(s.Caveat)):
+					drawn = append(drawn, id(s.Caveat))
+					line := fmt.Sprintf("Rel_Association(%s,%s)", id(r), id(s.Caveat))
+					out = append(out, line)
 				}
 			}
 		}
 	}
+
+	out = plantUMLArchimateSchema.appendFooter(out)
+	return strings.Join(out, "\n")
 }
 
-// 'objectname:*' must be unique in zrel
+// @startuml, scale, dpi and the options
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) appendHeader(out []string, pngfilename string) []string {
+	out = append(out, "@startuml "+pngfilename)
+	out = append(out, "!include <archimate/Archimate>")
 
-func (plantUMLArchimateSchema *PlantUMLArchimateSchema) verifyUniqueObjectWildCardForEachRelation() {
-	for _, zdef := range plantUMLArchimateSchema.Zdefs {
-		for _, zrel := range zdef.Relations {
-			keySetObjectSlice := []string{}
-			for _, zobjectWildCard := range zrel.ZobjectWildCards {
-				varname := zobjectWildCard.Name
-				if contains(keySetObjectSlice, varname) {
-					zobjectWildCard.Unique = false
-					fmt.Printf("wildcard %s:* is declared more that one in relation %s of definition %s\n", varname, zrel.Name, zdef.Name)
-				} else {
-					zobjectWildCard.Unique = true
-					keySetObjectSlice = append(keySetObjectSlice, varname)
-				}
+	// 1.0 and 96 when they are not set
+	scale, dpi := plantUMLArchimateSchema.SchemaScale, plantUMLArchimateSchema.SchemaDpi
+	if scale == 0 {
+		scale = 1.0
+	}
+	if dpi == 0 {
+		dpi = 96
+	}
+	out = append(out, "scale "+formatScale(scale))
+	out = append(out, fmt.Sprintf("skinparam dpi %d", dpi))
+	return append(out, plantUMLOptions(plantUMLArchimateSchema.Options)...)
+}
+
+// definitions with a prefix are drawn in a grouping named after the prefix
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) appendDefinitions(out []string) []string {
+	id := plantUMLArchimateSchema.id
+	red := plantUMLColor(plantUMLArchimateSchema.Options.errorColor("red"))
+
+	prefixes := []string{}
+	for _, d := range plantUMLArchimateSchema.Schema.Definitions {
+		zdef := d.Source
+		if d.Duplicate {
+			line := fmt.Sprintf("rectangle \"definition %s is declared more than one \" %s", zdef.FullName(), red)
+			out = append(out, line)
+		} else if zdef.Prefix == "" {
+			out = append(out, plantUMLArchimateSchema.definitionObject(d))
+			out = plantUMLArchimateSchema.appendNote(out, id(d), zdef.Doc)
+		} else if !contains(prefixes, zdef.Prefix) {
+			prefixes = append(prefixes, zdef.Prefix)
+		}
+	}
+
+	for _, prefix := range prefixes {
+		out = append(out, fmt.Sprintf("Grouping(%s,\"%s\") {", id(groupingKey(prefix)), prefix))
+		for _, d := range plantUMLArchimateSchema.Schema.Definitions {
+			if d.Source.Prefix == prefix && !d.Duplicate {
+				out = append(out, plantUMLArchimateSchema.definitionObject(d))
+				out = plantUMLArchimateSchema.appendNote(out, id(d), d.Source.Doc)
 			}
 		}
+		out = append(out, "}")
+	}
+	return out
+}
+
+// a definition with a link is drawn without the macro, to add the link
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) definitionObject(d *Definition) string {
+	if url, ok := plantUMLArchimateSchema.Links[d.Name()]; ok {
+		return fmt.Sprintf("archimate #Business \"%s\" as %s <<business-object>> [[%s]]", d.Source.Name, plantUMLArchimateSchema.id(d), url)
+	}
+	return fmt.Sprintf("Business_Object(%s,\"%s\")", plantUMLArchimateSchema.id(d), d.Source.Name)
+}
+
+// the legend and @enduml
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) appendFooter(out []string) []string {
+	options := plantUMLArchimateSchema.Options
+	red := plantUMLColor(options.errorColor("red"))
+	if options.Legend {
+		out = append(out, "legend right")
+		out = append(out, "definition, <<relation>> and <<permission>> : Business Objects")
+		out = append(out, "relation --> definition : subject type, ALL for a wildcard")
+		out = append(out, "relation --> relation : subject set definition#relation")
+		out = append(out, "permission --> relation : relation or permission used by the permission")
+		out = append(out, fmt.Sprintf("<back:%s> error </back>", red))
+		out = append(out, "endlegend")
+	}
+	return append(out, "@enduml")
+}
+
+// direction, skinparams in the order of their names, then title
+func plantUMLOptions(options RenderOptions) []string {
+	var out []string
+	switch options.Direction {
+	case "LR", "RL":
+		out = append(out, "left to right direction")
+	case "TB", "BT":
+		out = append(out, "top to bottom direction")
+	}
+	names := []string{}
+	for name := range options.SkinParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out = append(out, fmt.Sprintf("skinparam %s %s", name, options.SkinParams[name]))
+	}
+	if options.Title != "" {
+		out = append(out, "title "+options.Title)
+	}
+	return out
+}
+
+// 1 is written 1.0, as before the options
+func formatScale(scale float64) string {
+	text := strconv.FormatFloat(scale, 'f', -1, 64)
+	if !strings.Contains(text, ".") {
+		text += ".0"
+	}
+	return text
+}
+
+// a Doc is drawn as a note attached to the element id
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) appendNote(out []string, id string, doc string) []string {
+	text := CommentText(doc)
+	if plantUMLArchimateSchema.HideDocs || text == "" {
+		return out
+	}
+	out = append(out, fmt.Sprintf("note right of %s", id))
+	out = append(out, strings.Split(text, "\n")...)
+	out = append(out, "end note")
+	return out
+}
+
+// utility
+func withCaveat(label string, caveat string) string {
+	if caveat == "" {
+		return label
+	}
+	if label == "" {
+		return "with " + caveat
+	}
+	return label + " with " + caveat
+}
+
+func contains(slice []string, item string) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func subjectsOfKind(r *Relation, kind SubjectKind) []*Subject {
+	subjects := []*Subject{}
+	for _, s := range r.Subjects {
+		if s.Kind == kind {
+			subjects = append(subjects, s)
+		}
+	}
+	return subjects
+}
+
+// objects, then subject sets, then wildcards
+func orderedSubjects(r *Relation) []*Subject {
+	subjects := subjectsOfKind(r, ObjectSubject)
+	subjects = append(subjects, subjectsOfKind(r, SubjectSet)...)
+	return append(subjects, subjectsOfKind(r, WildcardSubject)...)
+}
+
+// every element of the compiled schema gets a PlantUML variable derived from its name
+// d_ for the definitions, r_ for the relations, p_ for the permissions, c_ for the caveats and g_ for the prefixes
+// so adding an element to the schema does not change the variables of the others
+
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) createIDforZdef() {
+	if plantUMLArchimateSchema.Schema == nil {
+		plantUMLArchimateSchema.Schema, _ = Compile(plantUMLArchimateSchema.Zdefs, plantUMLArchimateSchema.Zcaveats)
+	}
+	plantUMLArchimateSchema.ids = schemaIDs(plantUMLArchimateSchema.Schema)
+}
+
+// a prefix is a key of the schemaIDs map
+type groupingKey string
+
+// schemaIDs gives the variables of the elements of a schema, shared by the generators
+// names sanitized the same way get the suffix _2, _3... in the order of the names, not of the schema,
+// so that moving an element does not swap the variables, a duplicate gets the suffix after the element it duplicates
+func schemaIDs(schema *Schema) map[interface{}]string {
+	type candidate struct {
+		element interface{}
+		id      string
+		name    string
+	}
+	var candidates []candidate
+	seenPrefixes := make(map[groupingKey]bool)
+	for _, d := range schema.Definitions {
+		name := sanitizeID(d.Name())
+		candidates = append(candidates, candidate{d, "d_" + name, d.Name()})
+		for _, r := range d.Relations {
+			candidates = append(candidates, candidate{r, "r_" + name + "_" + sanitizeID(r.Name()), d.Name() + "#" + r.Name()})
+		}
+		for _, p := range d.Permissions {
+			candidates = append(candidates, candidate{p, "p_" + name + "_" + sanitizeID(p.Name()), d.Name() + "#" + p.Name()})
+		}
+		if prefix := groupingKey(d.Source.Prefix); prefix != "" && !d.Duplicate && !seenPrefixes[prefix] {
+			seenPrefixes[prefix] = true
+			candidates = append(candidates, candidate{prefix, "g_" + sanitizeID(d.Source.Prefix), d.Source.Prefix})
+		}
 	}
+	for _, caveat := range schema.Caveats {
+		candidates = append(candidates, candidate{caveat, "c_" + sanitizeID(caveat.Name()), caveat.Name()})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].id != candidates[j].id {
+			return candidates[i].id < candidates[j].id
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	ids := make(map[interface{}]string)
+	used := make(map[string]bool)
+	for _, c := range candidates {
+		unique := c.id
+		for n := 2; used[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", c.id, n)
+		}
+		used[unique] = true
+		ids[c.element] = unique
+	}
+	return ids
+}
+
+// sanitizeID replaces the characters other than letters, digits and _ by _
+func sanitizeID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) id(element interface{}) string {
+	return plantUMLArchimateSchema.ids[element]
 }
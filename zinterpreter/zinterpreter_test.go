@@ -2,6 +2,7 @@ package zinterpreter
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -110,3 +111,628 @@ func TestWildcardRelationInDefinition(t *testing.T) {
 		}
 	}
 }
+
+func TestReadPermission(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    string
+		expectError bool
+	}{
+		{input: `definition doc { relation reader: user permission view = reader }`, expected: "reader", expectError: false},
+		{input: `definition doc { relation reader: user relation writer: user permission view = reader + writer }`, expected: "reader + writer", expectError: false},
+		{input: `definition doc { permission view = reader + writer & owner - banned }`, expected: "reader + writer & owner - banned", expectError: false},
+		{input: `definition doc { permission view = (reader - banned) + writer }`, expected: "(reader - banned) + writer", expectError: false},
+		{input: `definition doc { permission view = reader - (banned - owner) }`, expected: "reader - (banned - owner)", expectError: false},
+		{input: `definition doc { permission view = nil }`, expected: "nil", expectError: false},
+		{input: `definition doc { permission permission_admin = reader }`, expected: "reader", expectError: false},
+		{input: `definition doc { permission view = reader + }`, expectError: true},
+		{input: `definition doc { permission view = (reader }`, expectError: true},
+		{input: `definition doc { permission view reader }`, expectError: true},
+	}
+
+	for _, tt := range tests {
+		lexer := NewLexer(tt.input)
+		lexer.NextToken()
+		z, err := lexer.ReadZSchema()
+
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("expected an error but got none for input: %s", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect an error but got one for input: %s, error: %v", tt.input, err)
+			continue
+		}
+		if got := z[0].Permissions[0].Expression.String(); got != tt.expected {
+			t.Errorf("expected expression %s but got %s for input: %s", tt.expected, got, tt.input)
+		}
+	}
+}
+
+func TestPermissionExpressionPrecedence(t *testing.T) {
+	lexer := NewLexer(`definition doc { permission view = a - b & c + d }`)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+
+	exclusion, ok := z[0].Permissions[0].Expression.(*ZExprBinary)
+	if !ok || exclusion.Operator != ExclusionOperator {
+		t.Fatalf("expected an exclusion at the root of the expression")
+	}
+	intersection, ok := exclusion.Right.(*ZExprBinary)
+	if !ok || intersection.Operator != IntersectionOperator {
+		t.Fatalf("expected an intersection on the right of the exclusion")
+	}
+	union, ok := intersection.Right.(*ZExprBinary)
+	if !ok || union.Operator != UnionOperator {
+		t.Fatalf("expected a union on the right of the intersection")
+	}
+}
+
+func TestPermissionReferences(t *testing.T) {
+	input := `definition user { } definition doc { relation reader: user permission view = reader + edit permission edit = writer permission reader = reader }`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+
+	schema, diagnostics := Compile(z, nil)
+
+	doc := schema.Definition("doc")
+	refs := doc.Permissions[0].References
+	if refs[0].Relation != doc.Relation("reader") || refs[1].Permission != doc.Permission("edit") {
+		t.Errorf("expected view to reference reader and edit")
+	}
+	if doc.Permissions[1].References[0].Resolved() {
+		t.Errorf("expected writer to be unknown in edit")
+	}
+	if !doc.Permissions[2].Duplicate || doc.Permission("reader") != nil {
+		t.Errorf("expected permission reader to clash with relation reader")
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("expected 2 diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestReadArrow(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    string
+		expectError bool
+	}{
+		{input: `definition doc { relation parent: folder permission view = parent->view }`, expected: "parent->view", expectError: false},
+		{input: `definition doc { relation parent: folder permission view = reader + parent->view }`, expected: "reader + parent->view", expectError: false},
+		{input: `definition doc { relation parent: folder permission view = parent.any(view) - banned }`, expected: "parent.any(view) - banned", expectError: false},
+		{input: `definition doc { relation parent: folder permission view = parent.all(member) }`, expected: "parent.all(member)", expectError: false},
+		{input: `definition doc { permission view = parent-> }`, expectError: true},
+		{input: `definition doc { permission view = parent.some(view) }`, expectError: true},
+		{input: `definition doc { permission view = parent.any(view }`, expectError: true},
+	}
+
+	for _, tt := range tests {
+		lexer := NewLexer(tt.input)
+		lexer.NextToken()
+		z, err := lexer.ReadZSchema()
+
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("expected an error but got none for input: %s", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect an error but got one for input: %s, error: %v", tt.input, err)
+			continue
+		}
+		if got := z[0].Permissions[0].Expression.String(); got != tt.expected {
+			t.Errorf("expected expression %s but got %s for input: %s", tt.expected, got, tt.input)
+		}
+	}
+}
+
+func TestArrowResolution(t *testing.T) {
+	input := `definition user { }
+	definition organization { relation admin: user permission view = admin }
+	definition folder { relation reader: user permission view = reader }
+	definition doc {
+		relation parent: folder | organization
+		relation owner: folder
+		permission view = parent->view
+		permission edit = owner->reader + parent.any(reader)
+		permission admin = missing->view
+		permission manage = view->view
+	}`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+
+	schema, _ := Compile(z, nil)
+
+	doc := schema.Definition("doc")
+	view := doc.Permissions[0].Arrows[0]
+	if view.Relation != doc.Relation("parent") || len(view.MissingIn) != 0 {
+		t.Errorf("expected parent->view to be resolved, got %v", view.MissingIn)
+	}
+	edit := doc.Permissions[1].Arrows
+	if len(edit[0].MissingIn) != 0 {
+		t.Errorf("expected owner->reader to be resolved, got %v", edit[0].MissingIn)
+	}
+	if len(edit[1].MissingIn) != 1 || edit[1].MissingIn[0] != schema.Definition("organization") {
+		t.Errorf("expected reader to be missing in organization, got %v", edit[1].MissingIn)
+	}
+	if doc.Permissions[2].Arrows[0].Relation != nil {
+		t.Errorf("expected missing->view to be unresolved")
+	}
+	if doc.Permissions[3].Arrows[0].Relation != nil {
+		t.Errorf("expected view->view to be unresolved since view is a permission")
+	}
+}
+
+func TestReadCaveat(t *testing.T) {
+	tests := []struct {
+		input       string
+		signature   string
+		expression  string
+		expectError bool
+	}{
+		{input: `caveat ip_allowlist(user_ip ipaddress, cidr string) { user_ip.in_cidr(cidr) }`, signature: "ip_allowlist(user_ip ipaddress, cidr string)", expression: "user_ip.in_cidr(cidr)", expectError: false},
+		{input: `caveat has_tag(tags list<string>, limits map<list<int>>) { "admin" in tags && {"a": 1}["a"] == 1.5 }`, signature: "has_tag(tags list<string>, limits map<list<int>>)", expression: `"admin" in tags && {"a": 1}["a"] == 1.5`, expectError: false},
+		{input: `caveat brace(name string) { name == "}" }`, signature: "brace(name string)", expression: `name == "}"`, expectError: false},
+		{input: `caveat missing_brace(name string) { name == "x" `, expectError: true},
+		{input: `caveat no_params() { true }`, expectError: true},
+		{input: `caveat bad_type(name list<string) { true }`, expectError: true},
+	}
+
+	for _, tt := range tests {
+		lexer := NewLexer(tt.input)
+		lexer.NextToken()
+		_, err := lexer.ReadZSchema()
+
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("expected an error but got none for input: %s", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect an error but got one for input: %s, error: %v", tt.input, err)
+			continue
+		}
+		zcaveat := lexer.ZCaveats()[0]
+		if zcaveat.Signature() != tt.signature {
+			t.Errorf("expected signature %s but got %s", tt.signature, zcaveat.Signature())
+		}
+		if zcaveat.Expression != tt.expression {
+			t.Errorf("expected expression %s but got %s", tt.expression, zcaveat.Expression)
+		}
+	}
+}
+
+func TestWithCaveatInRelation(t *testing.T) {
+	input := `caveat only_weekdays(day int) { day < 6 }
+	definition user { }
+	definition group { relation member: user }
+	definition document {
+		relation viewer: user with only_weekdays | user | user:* with only_weekdays | group#member with only_weekdays
+		relation editor: user with unknown_caveat
+		relation withdrawer: user
+	}`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+
+	schema, _ := Compile(z, lexer.ZCaveats())
+	onlyWeekdays := schema.Caveat("only_weekdays")
+
+	viewer := schema.Definition("document").Relation("viewer")
+	if viewer.Subjects[0].CaveatName != "only_weekdays" || viewer.Subjects[0].Caveat != onlyWeekdays {
+		t.Errorf("expected user with only_weekdays, got %s", viewer.Subjects[0].CaveatName)
+	}
+	if viewer.Subjects[0].Duplicate || viewer.Subjects[1].Duplicate {
+		t.Errorf("expected user and user with only_weekdays to be distinct subject types")
+	}
+	if viewer.Subjects[2].Caveat != onlyWeekdays || viewer.Subjects[3].Caveat != onlyWeekdays {
+		t.Errorf("expected subject set and wildcard to reference only_weekdays")
+	}
+	if editor := schema.Definition("document").Relation("editor"); editor.Subjects[0].Caveat != nil || editor.Subjects[0].Resolved() {
+		t.Errorf("expected unknown_caveat to be reported")
+	}
+	if z[2].Relations[2].Name != "withdrawer" {
+		t.Errorf("expected withdrawer to be read as an identifier, got %s", z[2].Relations[2].Name)
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	lexer := NewFileLexer("schema.zed", "definition user {\n  relation owner: user\n}")
+	expected := []struct {
+		value  string
+		line   int
+		col    int
+		offset int
+	}{
+		{"definition", 1, 1, 0},
+		{"user", 1, 12, 11},
+		{"{", 1, 17, 16},
+		{"relation", 2, 3, 20},
+		{"owner", 2, 12, 29},
+		{":", 2, 17, 34},
+		{"user", 2, 19, 36},
+		{"}", 3, 1, 41},
+	}
+
+	for _, e := range expected {
+		item := lexer.NextToken()
+		start := item.Span.Start
+		if item.Value != e.value || start.Line != e.line || start.Col != e.col || start.Offset != e.offset || start.File != "schema.zed" {
+			t.Errorf("expected %s at %d:%d (%d), got %s at %s (%d)", e.value, e.line, e.col, e.offset, item.Value, start, start.Offset)
+		}
+		if item.Span.End.Offset != e.offset+len(e.value) {
+			t.Errorf("expected %s to end at %d, got %d", e.value, e.offset+len(e.value), item.Span.End.Offset)
+		}
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	lexer := NewFileLexer("schema.zed", "definition user {\n  relation owner user\n}")
+	lexer.NextToken()
+	_, err := lexer.ReadZSchema()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := "schema.zed:2:18: expected token ':', but got 'user'"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	input := "definition user {}\ndefinition doc {\n  relation reader: user | group#member with c | user:*\n  permission view = reader + parent->view\n}"
+	lexer := NewFileLexer("schema.zed", input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+
+	text := func(s Span) string {
+		return input[s.Start.Offset:s.End.Offset]
+	}
+	doc := z[1]
+	if got := text(z[0].Span); got != "definition user {}" {
+		t.Errorf("unexpected definition span %q", got)
+	}
+	if doc.Span.Start.Line != 2 || doc.Span.End.Line != 5 {
+		t.Errorf("unexpected definition lines %d-%d", doc.Span.Start.Line, doc.Span.End.Line)
+	}
+	if got := text(doc.Relations[0].Span); got != "relation reader: user | group#member with c | user:*" {
+		t.Errorf("unexpected relation span %q", got)
+	}
+	if got := text(doc.Relations[0].Zobjects[0].Span); got != "user" {
+		t.Errorf("unexpected object span %q", got)
+	}
+	if got := text(doc.Relations[0].ZobjectSets[0].Span); got != "group#member with c" {
+		t.Errorf("unexpected object set span %q", got)
+	}
+	if got := text(doc.Relations[0].ZobjectWildCards[0].Span); got != "user:*" {
+		t.Errorf("unexpected wildcard span %q", got)
+	}
+	if got := text(doc.Permissions[0].Span); got != "permission view = reader + parent->view" {
+		t.Errorf("unexpected permission span %q", got)
+	}
+	if got := text(zexprArrows(doc.Permissions[0].Expression)[0].Span); got != "parent->view" {
+		t.Errorf("unexpected arrow span %q", got)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// the users
+definition user {}
+
+/**
+ * a document
+ * with its owner
+ */
+definition document {
+	// owner of the doc
+	relation owner: user // trailing comment
+	/* who can view */
+	permission view = owner // the owners
+	relation viewer: user /* the viewers */
+	relation editor: user /* inner */ // editors
+}
+definition group {} // the groups
+/* unused`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err == nil {
+		t.Fatalf("expected an error for the unterminated comment")
+	}
+	if len(z) != 3 {
+		t.Fatalf("expected 3 definitions, got %d", len(z))
+	}
+
+	tests := []struct {
+		doc      string
+		expected string
+	}{
+		{z[0].Doc, "the users"},
+		{z[1].Doc, "a document\nwith its owner"},
+		{z[1].Relations[0].Doc, "owner of the doc\ntrailing comment"},
+		{z[1].Permissions[0].Doc, "who can view\nthe owners"},
+		{z[1].Relations[1].Doc, "the viewers"},
+		{z[1].Relations[2].Doc, "inner\neditors"},
+		{z[1].EndDoc, ""},
+		{z[2].Doc, "the groups"},
+	}
+	for _, tt := range tests {
+		if got := CommentText(tt.doc); got != tt.expected {
+			t.Errorf("expected doc %q, got %q", tt.expected, got)
+		}
+	}
+	if z[0].Doc != "// the users" {
+		t.Errorf("expected the doc to be kept as written, got %q", z[0].Doc)
+	}
+}
+
+func TestDocNotes(t *testing.T) {
+	input := `/* the users */ definition user {} definition doc { // readers
+	relation reader: user }`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+
+	mydraw := PlantUMLArchimateSchema{Zdefs: z}
+	out := mydraw.Generate("doc")
+	if !strings.Contains(out, "note right of d_user\nthe users\nend note") || !strings.Contains(out, "note right of r_doc_reader\nreaders\nend note") {
+		t.Errorf("expected notes in generated code:\n%s", out)
+	}
+
+	mydraw = PlantUMLArchimateSchema{Zdefs: z, HideDocs: true}
+	if out := mydraw.Generate("doc"); strings.Contains(out, "note") {
+		t.Errorf("did not expect notes in generated code:\n%s", out)
+	}
+}
+
+func TestNamespacedDefinitions(t *testing.T) {
+	input := `definition user {}
+	definition acme/user {}
+	definition acme/group { relation member: user }
+	definition acme/billing/invoice { relation viewer: acme/group#member | user:* | acme/unknown }
+	definition other/document { relation viewer: user | acme/user }`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+
+	if z[1].Prefix != "acme" || z[1].Name != "user" || z[1].FullName() != "acme/user" {
+		t.Errorf("unexpected prefix and name %s %s", z[1].Prefix, z[1].Name)
+	}
+	if z[3].Prefix != "acme/billing" || z[3].Name != "invoice" {
+		t.Errorf("unexpected prefix and name %s %s", z[3].Prefix, z[3].Name)
+	}
+
+	mydraw := PlantUMLArchimateSchema{Zdefs: z}
+	out := mydraw.Generate("acme")
+
+	schema := mydraw.Schema
+	user, acmeUser, acmeGroup := schema.Definition("user"), schema.Definition("acme/user"), schema.Definition("acme/group")
+
+	// user in acme/group is acme/user
+	if acmeGroup.Relation("member").Subjects[0].Target != acmeUser {
+		t.Errorf("expected user to be resolved as acme/user")
+	}
+	invoice := schema.Definition("acme/billing/invoice").Relation("viewer")
+	if invoice.Subjects[0].Target != nil {
+		t.Errorf("expected acme/unknown to be unresolved")
+	}
+	if invoice.Subjects[1].Target != acmeGroup || invoice.Subjects[1].TargetRelation != acmeGroup.Relation("member") {
+		t.Errorf("expected acme/group#member to be resolved")
+	}
+	if invoice.Subjects[2].Target != user {
+		t.Errorf("expected user:* to be resolved as user in acme/billing/invoice")
+	}
+	// user in other/document is user
+	document := schema.Definition("other/document").Relation("viewer")
+	if document.Subjects[0].Target != user || document.Subjects[1].Target != acmeUser {
+		t.Errorf("expected user and acme/user to be resolved in other/document")
+	}
+
+	if !strings.Contains(out, "Grouping(g_acme,\"acme\") {\nBusiness_Object(d_acme_user,\"user\")\nBusiness_Object(d_acme_group,\"group\")\n}") {
+		t.Errorf("expected acme definitions in a grouping:\n%s", out)
+	}
+	if !strings.Contains(out, "Grouping(g_acme_billing,\"acme/billing\") {") || !strings.Contains(out, "Grouping(g_other,\"other\") {") {
+		t.Errorf("expected a grouping for each prefix:\n%s", out)
+	}
+}
+
+func TestKeywordBoundaries(t *testing.T) {
+	input := `definition definitions { relation relationship: relation_user | _private permission permissions = relationship }`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+	if z[0].Name != "definitions" || z[0].Relations[0].Name != "relationship" || z[0].Permissions[0].Name != "permissions" {
+		t.Errorf("expected keywords to be matched on whole words, got %s %s %s", z[0].Name, z[0].Relations[0].Name, z[0].Permissions[0].Name)
+	}
+	if z[0].Relations[0].Zobjects[0].Name != "relation_user" || z[0].Relations[0].Zobjects[1].Name != "_private" {
+		t.Errorf("unexpected subject types %s %s", z[0].Relations[0].Zobjects[0].Name, z[0].Relations[0].Zobjects[1].Name)
+	}
+}
+
+func TestInvalidCharacters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "definition café {}", expected: "1:15: expected token '{', but got invalid character 'é' (U+00E9)"},
+		{input: "definition user {}\n// é\ndefinition 文書 {}", expected: "3:12: expected token 'Identifier', but got invalid character '文' (U+6587)"},
+		{input: "definition doc { relation r: user }\ndefinition x { é }", expected: "2:16: expected 'relation', 'permission' or '}', but got invalid character 'é' (U+00E9)"},
+		{input: "definition 9doc {}", expected: "1:12: expected token 'Identifier', but got '9'"},
+		{input: "definition doc { relation r: user } /* comment", expected: "1:37: expected token 'definition', but got unterminated comment"},
+		{input: "definition doc \xff {}", expected: "1:16: expected token '{', but got invalid byte \"\\xff\""},
+		{input: "definition doc {", expected: "1:17: expected token '}', but got end of file"},
+	}
+
+	for _, tt := range tests {
+		lexer := NewLexer(tt.input)
+		lexer.NextToken()
+		_, err := lexer.ReadZSchema()
+		if err == nil {
+			t.Errorf("expected an error for input: %s", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestRuneColumns(t *testing.T) {
+	lexer := NewLexer("// é文\n/* ü */ definition")
+	item := lexer.NextToken()
+	if item.Token != DefinitionToken || item.Span.Start.Line != 2 || item.Span.Start.Col != 9 {
+		t.Errorf("expected definition at 2:9, got %s at %s", item.Value, item.Span.Start)
+	}
+	if item.Span.Start.Offset != len("// é文\n/* ü */ ") {
+		t.Errorf("expected a byte offset, got %d", item.Span.Start.Offset)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `definition user {}
+definition group {
+	relation member user
+	relation admin: user
+}
+definition {
+	relation broken: user
+}
+definition document {
+	relation viewer: user | group#
+	permission view = viewer +
+	relation editor: user
+	permission edit = editor
+	oops
+}
+caveat bad( { }
+definition missing_brace {
+	relation owner: user
+definition folder {
+	relation parent: folder
+}`
+	lexer := NewFileLexer("schema.zed", input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+
+	errorList, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList, got %v", err)
+	}
+	expected := []string{
+		"schema.zed:3:18: expected token ':', but got 'user'",
+		"schema.zed:6:12: expected token 'Identifier', but got '{'",
+		"schema.zed:11:2: expected token 'Identifier', but got 'permission'",
+		"schema.zed:12:2: expected a relation, a permission, 'nil' or '(', but got 'relation'",
+		"schema.zed:14:2: expected 'relation', 'permission' or '}', but got 'oops'",
+		"schema.zed:16:13: expected token 'Identifier', but got '{'",
+		"schema.zed:19:1: expected token '}', but got 'definition'",
+	}
+	if len(errorList) != len(expected) {
+		t.Fatalf("expected %d errors, got %d:\n%v", len(expected), len(errorList), err)
+	}
+	for i, e := range expected {
+		if errorList[i].Error() != e {
+			t.Errorf("expected %q, got %q", e, errorList[i].Error())
+		}
+	}
+
+	names := []string{}
+	for _, zdef := range z {
+		names = append(names, zdef.Name)
+	}
+	if strings.Join(names, " ") != "user group document folder" {
+		t.Fatalf("unexpected definitions %v", names)
+	}
+	if len(z[1].Relations) != 1 || z[1].Relations[0].Name != "admin" {
+		t.Errorf("expected admin to be read in group")
+	}
+	if len(z[2].Relations) != 1 || z[2].Relations[0].Name != "editor" || len(z[2].Permissions) != 1 || z[2].Permissions[0].Name != "edit" {
+		t.Errorf("expected editor and edit to be read in document")
+	}
+	if len(z[3].Relations) != 1 {
+		t.Errorf("expected parent to be read in folder")
+	}
+}
+
+func TestSchemaIDs(t *testing.T) {
+	schema, _ := compileInput(t, `definition acme/doc { relation viewer: user permission view = viewer }
+definition acme_doc { relation viewer: user relation viewer: user }
+definition user {}
+definition user {}
+caveat weekday(day int) { day < 6 }`)
+
+	ids := schemaIDs(schema)
+	acme, acmeDoc := schema.Definitions[0], schema.Definitions[1]
+	for _, tt := range []struct {
+		element  interface{}
+		expected string
+	}{
+		{acme, "d_acme_doc"},
+		{acme.Relations[0], "r_acme_doc_viewer"},
+		{acme.Permissions[0], "p_acme_doc_view"},
+		{groupingKey("acme"), "g_acme"},
+		{acmeDoc, "d_acme_doc_2"},
+		{acmeDoc.Relations[0], "r_acme_doc_viewer_2"},
+		{acmeDoc.Relations[1], "r_acme_doc_viewer_3"},
+		{schema.Definitions[2], "d_user"},
+		{schema.Definitions[3], "d_user_2"},
+		{schema.Caveats[0], "c_weekday"},
+	} {
+		if ids[tt.element] != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, ids[tt.element])
+		}
+	}
+
+	// the suffix follows the names, not their order in the schema
+	swapped, _ := compileInput(t, `definition acme_doc { relation viewer: user } definition acme/doc { relation viewer: user }`)
+	swappedIDs := schemaIDs(swapped)
+	for name, expected := range map[string]string{"acme/doc": "d_acme_doc", "acme_doc": "d_acme_doc_2"} {
+		if got := swappedIDs[swapped.Definition(name)]; got != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, got)
+		}
+	}
+	if got := swappedIDs[swapped.Definition("acme/doc").Relation("viewer")]; got != "r_acme_doc_viewer" {
+		t.Errorf("expected r_acme_doc_viewer, got %s", got)
+	}
+
+	// a new definition does not change the lines of the others
+	before, _ := compileInput(t, `definition user {} definition doc { relation viewer: user }`)
+	after, _ := compileInput(t, `definition group { relation member: user } definition user {} definition doc { relation viewer: user }`)
+	beforeOut := plantUMLRenderer{}.Render(before, RenderOptions{Name: "doc"})
+	afterOut := plantUMLRenderer{}.Render(after, RenderOptions{Name: "doc"})
+	for _, line := range strings.Split(beforeOut, "\n") {
+		if !strings.Contains(afterOut, line) {
+			t.Errorf("expected %s in:\n%s", line, afterOut)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package zinterpreter
+
+// Linter
+//
+// Lint checks a compiled schema against rules that are not errors for SpiceDB
+// but often are mistakes : a definition used nowhere, a name SpiceDB does not accept,
+// a wildcard on a sensitive type...
+// every rule can be disabled, and its severity changed, by a LintConfig
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// lint codes
+const (
+	CodeUnusedDefinition  = "unused-definition"
+	CodeUnusedRelation    = "unused-relation"
+	CodeNaming            = "naming"
+	CodeSensitiveWildcard = "sensitive-wildcard"
+	CodeLongUnion         = "long-union"
+)
+
+// LintConfig is the "lint" part of the configuration file
+//
+//	{ "rules": { "naming": { "enabled": false }, "long-union": { "severity": "error", "max": 5 } } }
+type LintConfig struct {
+	Rules map[string]RuleConfig `json:"rules"`
+}
+
+// a field not set keeps the default of the rule
+type RuleConfig struct {
+	Enabled  *bool    `json:"enabled,omitempty"`
+	Severity string   `json:"severity,omitempty"` // "error" or "warning"
+	Max      int      `json:"max,omitempty"`      // long-union : maximum number of subject types of a relation
+	Types    []string `json:"types,omitempty"`    // sensitive-wildcard : the definitions that must not be given to everyone
+}
+
+type lintRule struct {
+	code     string
+	severity Severity
+	enabled  bool
+	summary  string
+	check    func(schema *Schema, rule RuleConfig, report func(span Span, related []*Definition, format string, args ...interface{}))
+}
+
+const defaultMaxSubjects = 8
+
+// sensitive-wildcard checks nothing without the types of its configuration
+var lintRules = []*lintRule{
+	{CodeUnusedDefinition, SeverityWarning, true, "a definition without relation nor permission is not used as a subject type", lintUnusedDefinitions},
+	{CodeUnusedRelation, SeverityWarning, true, "a relation of a definition with permissions is used by no subject set, permission or arrow", lintUnusedRelations},
+	{CodeNaming, SeverityWarning, true, "a name is lowercase, from 3 to 64 characters, as required by SpiceDB", lintNaming},
+	{CodeSensitiveWildcard, SeverityWarning, true, "a definition listed in the types of its configuration is not given to everyone with a wildcard", lintSensitiveWildcards},
+	{CodeLongUnion, SeverityWarning, true, fmt.Sprintf("a relation has at most %d subject types", defaultMaxSubjects), lintLongUnions},
+}
+
+// LintRules returns the code and the summary of every rule, for the help
+func LintRules() [][2]string {
+	rules := [][2]string{}
+	for _, rule := range lintRules {
+		rules = append(rules, [2]string{rule.code, rule.summary})
+	}
+	return rules
+}
+
+// Validate returns an error for an unknown rule or severity
+func (config LintConfig) Validate() error {
+	codes := []string{}
+	for code := range config.Rules {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		if findLintRule(code) == nil {
+			return fmt.Errorf("unknown lint rule %s", code)
+		}
+		if _, err := parseSeverity(config.Rules[code].Severity); err != nil {
+			return fmt.Errorf("lint rule %s: %v", code, err)
+		}
+	}
+	return nil
+}
+
+func findLintRule(code string) *lintRule {
+	for _, rule := range lintRules {
+		if rule.code == code {
+			return rule
+		}
+	}
+	return nil
+}
+
+func parseSeverity(severity string) (Severity, error) {
+	switch severity {
+	case "", "warning":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	default:
+		return SeverityWarning, fmt.Errorf("unknown severity %s", severity)
+	}
+}
+
+// Lint returns the diagnostics of the enabled rules
+// the duplicated elements are ignored, as they are reported by Compile
+func Lint(schema *Schema, config LintConfig) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, rule := range lintRules {
+		ruleConfig := config.Rules[rule.code]
+		enabled := rule.enabled
+		if ruleConfig.Enabled != nil {
+			enabled = *ruleConfig.Enabled
+		}
+		if !enabled {
+			continue
+		}
+		severity := rule.severity
+		if ruleConfig.Severity != "" {
+			severity, _ = parseSeverity(ruleConfig.Severity)
+		}
+		rule.check(schema, ruleConfig, func(span Span, related []*Definition, format string, args ...interface{}) {
+			diagnostic := Diagnostic{Code: rule.code, Severity: severity, Message: fmt.Sprintf(format, args...), Span: span}
+			for _, d := range related {
+				diagnostic.Related = append(diagnostic.Related, d.Name())
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		})
+	}
+	return diagnostics
+}
+
+func lintUnusedDefinitions(schema *Schema, rule RuleConfig, report func(Span, []*Definition, string, ...interface{})) {
+	used := make(map[*Definition]bool)
+	for _, d := range schema.Definitions {
+		for _, r := range d.Relations {
+			for _, s := range r.Subjects {
+				if s.Target != nil {
+					used[s.Target] = true
+				}
+			}
+		}
+	}
+	for _, d := range schema.Definitions {
+		if d.Duplicate || used[d] || len(d.Relations) > 0 || len(d.Permissions) > 0 {
+			continue
+		}
+		report(d.Source.Span, []*Definition{d}, "definition %s is not used as a subject type and has no relation nor permission", d.Name())
+	}
+}
+
+func lintUnusedRelations(schema *Schema, rule RuleConfig, report func(Span, []*Definition, string, ...interface{})) {
+	used := make(map[*Relation]bool)
+	for _, d := range schema.Definitions {
+		for _, r := range d.Relations {
+			for _, s := range r.Subjects {
+				if s.TargetRelation != nil {
+					used[s.TargetRelation] = true
+				}
+			}
+		}
+		for _, p := range d.Permissions {
+			for _, ref := range p.References {
+				if ref.Relation != nil {
+					used[ref.Relation] = true
+				}
+			}
+			for _, arrow := range p.Arrows {
+				if arrow.Relation == nil {
+					continue
+				}
+				used[arrow.Relation] = true
+				for _, s := range arrow.Relation.Subjects {
+					if s.Target != nil && s.Target.Relation(arrow.Source.Target) != nil {
+						used[s.Target.Relation(arrow.Source.Target)] = true
+					}
+				}
+			}
+		}
+	}
+	// without permissions, every relation of a definition is checked directly
+	for _, d := range schema.Definitions {
+		if d.Duplicate || len(d.Permissions) == 0 {
+			continue
+		}
+		for _, r := range d.Relations {
+			if !r.Duplicate && !used[r] {
+				report(r.Source.Span, []*Definition{d}, "relation %s of definition %s is used by no subject set, permission or arrow", r.Name(), d.Name())
+			}
+		}
+	}
+}
+
+// SpiceDB : a type is [prefix/]name, a relation, a permission or a caveat is a name
+// a name starts with a lowercase letter, ends with a lowercase letter or a digit, and has from 3 to 64 characters
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]{1,62}[a-z0-9]$`)
+
+func lintNaming(schema *Schema, rule RuleConfig, report func(Span, []*Definition, string, ...interface{})) {
+	const expected = "lowercase letters, digits and '_', from 3 to 64 characters, starting with a letter"
+	for _, c := range schema.Caveats {
+		if !c.Duplicate && !validName.MatchString(c.Name()) {
+			report(c.Source.Span, nil, "caveat name %s must be %s", c.Name(), expected)
+		}
+	}
+	for _, d := range schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		for _, part := range strings.Split(d.Name(), "/") {
+			if !validName.MatchString(part) {
+				report(d.Source.Span, []*Definition{d}, "definition name %s must be %s", d.Name(), expected)
+				break
+			}
+		}
+		for _, r := range d.Relations {
+			if !r.Duplicate && !validName.MatchString(r.Name()) {
+				report(r.Source.Span, []*Definition{d}, "relation name %s of definition %s must be %s", r.Name(), d.Name(), expected)
+			}
+		}
+		for _, p := range d.Permissions {
+			if !p.Duplicate && !validName.MatchString(p.Name()) {
+				report(p.Source.Span, []*Definition{d}, "permission name %s of definition %s must be %s", p.Name(), d.Name(), expected)
+			}
+		}
+	}
+}
+
+func lintSensitiveWildcards(schema *Schema, rule RuleConfig, report func(Span, []*Definition, string, ...interface{})) {
+	for _, d := range schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		for _, r := range d.Relations {
+			for _, s := range r.Subjects {
+				if s.Kind == WildcardSubject && s.Target != nil && contains(rule.Types, s.Target.Name()) {
+					report(s.Span, []*Definition{d, s.Target}, "%s gives relation %s of definition %s to every %s", s.Label(), r.Name(), d.Name(), s.Target.Name())
+				}
+			}
+		}
+	}
+}
+
+func lintLongUnions(schema *Schema, rule RuleConfig, report func(Span, []*Definition, string, ...interface{})) {
+	max := rule.Max
+	if max <= 0 {
+		max = defaultMaxSubjects
+	}
+	for _, d := range schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		for _, r := range d.Relations {
+			if !r.Duplicate && len(r.Subjects) > max {
+				report(r.Source.Span, []*Definition{d}, "relation %s of definition %s has %d subject types, more than %d", r.Name(), d.Name(), len(r.Subjects), max)
+			}
+		}
+	}
+}
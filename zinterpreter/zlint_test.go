@@ -0,0 +1,84 @@
+package zinterpreter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {}
+definition orphan {}
+definition Bad_Name {}
+definition ab {}
+definition group { relation member: user | group#member }
+definition document {
+	relation viewer: user | user:* | group#member | ab
+	relation owner: user
+	relation banned: user
+	permission view = viewer + owner
+}`)
+
+	config := LintConfig{Rules: map[string]RuleConfig{
+		CodeSensitiveWildcard: {Types: []string{"user"}},
+		CodeLongUnion:         {Max: 3, Severity: "error"},
+	}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+	expected := []struct {
+		code     string
+		severity Severity
+		line     int
+	}{
+		{CodeUnusedDefinition, SeverityWarning, 2}, // orphan
+		{CodeUnusedDefinition, SeverityWarning, 3}, // Bad_Name
+		{CodeUnusedRelation, SeverityWarning, 9},   // banned, member of group is not checked without permissions
+		{CodeNaming, SeverityWarning, 3},           // Bad_Name
+		{CodeNaming, SeverityWarning, 4},           // ab
+		{CodeSensitiveWildcard, SeverityWarning, 7},
+		{CodeLongUnion, SeverityError, 7},
+	}
+	diagnostics := Lint(schema, config)
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d:\n%v", len(expected), len(diagnostics), diagnostics)
+	}
+	for i, e := range expected {
+		if d := diagnostics[i]; d.Code != e.code || d.Severity != e.severity || d.Span.Start.Line != e.line {
+			t.Errorf("expected %s %s at line %d, got %s", e.severity, e.code, e.line, d)
+		}
+	}
+
+	if message := diagnostics[2].Message; message != "relation banned of definition document is used by no subject set, permission or arrow" {
+		t.Errorf("unexpected message %s", message)
+	}
+
+	// sensitive-wildcard checks nothing without types, a rule can be disabled
+	disabled := false
+	config = LintConfig{Rules: map[string]RuleConfig{CodeUnusedRelation: {Enabled: &disabled}}}
+	for _, d := range Lint(schema, config) {
+		if d.Code == CodeUnusedRelation || d.Code == CodeSensitiveWildcard {
+			t.Errorf("did not expect %s", d)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	var config LintConfig
+	if err := json.Unmarshal([]byte(`{"rules": {"naming": {"enabled": false}, "long-union": {"severity": "error", "max": 5}}}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("did not expect an error: %v", err)
+	}
+	if *config.Rules[CodeNaming].Enabled || config.Rules[CodeLongUnion].Max != 5 {
+		t.Errorf("unexpected config %v", config)
+	}
+
+	for _, input := range []string{`{"rules": {"unknown": {}}}`, `{"rules": {"naming": {"severity": "fatal"}}}`} {
+		config := LintConfig{}
+		json.Unmarshal([]byte(input), &config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+}
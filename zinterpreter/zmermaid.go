@@ -0,0 +1,271 @@
+package zinterpreter
+
+// Mermaid generation
+//
+// the schema is drawn as a flowchart, rendered natively by GitHub and GitLab in markdown :
+// a definition is a node, in a subgraph named after its prefix
+// a relation is a rounded node linked to its definition, its subject types are arrows to their definitions,
+// a subject set is an arrow from the relation of the set, a wildcard is an arrow labeled ALL
+// a permission is a node with its expression, linked with dotted arrows to the relations and permissions it uses
+// a caveat is an hexagon
+// the errors are red nodes, as the red rectangles of the Archimate diagram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type MermaidSchema struct {
+	Zdefs    []*ZDef
+	Zcaveats []*ZCaveat
+	Schema   *Schema // compiled from Zdefs and Zcaveats when nil
+
+	HideDocs bool // do not draw the Doc comments as notes
+
+	Options RenderOptions // direction, title and colors
+
+	ids       map[interface{}]string // Mermaid node of each element of Schema
+	out       []string
+	errors    int
+	links     int   // linkStyle numbers the links from 0
+	wildcards []int // the links of the wildcards
+}
+
+type mermaidRenderer struct{}
+
+func init() {
+	RegisterRenderer(mermaidRenderer{})
+}
+
+func (mermaidRenderer) Name() string {
+	return "mermaid"
+}
+
+func (mermaidRenderer) Extension() string {
+	return ".mmd"
+}
+
+func (mermaidRenderer) Render(schema *Schema, options RenderOptions) string {
+	mydraw := MermaidSchema{Schema: options.schema(schema), HideDocs: options.HideDocs, Options: options}
+	return mydraw.Generate(options.Name)
+}
+
+// Generate returns the flowchart, title is written in the front matter when Options has no title
+func (mermaidSchema *MermaidSchema) Generate(title string) string {
+	if mermaidSchema.Schema == nil {
+		mermaidSchema.Schema, _ = Compile(mermaidSchema.Zdefs, mermaidSchema.Zcaveats)
+	}
+	mermaidSchema.ids = schemaIDs(mermaidSchema.Schema)
+	mermaidSchema.out = nil
+	mermaidSchema.errors = 0
+	mermaidSchema.links, mermaidSchema.wildcards = 0, nil
+	schema := mermaidSchema.Schema
+	options := mermaidSchema.Options
+	id := mermaidSchema.id
+	add := mermaidSchema.add
+	link := mermaidSchema.link
+
+	if options.Title != "" {
+		title = options.Title
+	}
+	if title != "" {
+		// a YAML string, that may contain ':' or '#'
+		mermaidSchema.out = append(mermaidSchema.out, "---", "title: "+strconv.Quote(title), "---")
+	}
+	direction := "LR"
+	if options.Direction != "" {
+		direction = options.Direction
+	}
+	mermaidSchema.out = append(mermaidSchema.out, "flowchart "+direction)
+
+	// definitions, with a prefix in a subgraph named after the prefix
+
+	prefixes := []string{}
+	for _, d := range schema.Definitions {
+		switch {
+		case d.Duplicate:
+			mermaidSchema.addError("definition %s is declared more than once", d.Name())
+		case d.Source.Prefix == "":
+			add("%s[\"%s\"]:::definition", id(d), mermaidText(d.Source.Name))
+			mermaidSchema.addNote(id(d), d.Source.Doc)
+		case !contains(prefixes, d.Source.Prefix):
+			prefixes = append(prefixes, d.Source.Prefix)
+		}
+	}
+	for _, prefix := range prefixes {
+		add("subgraph %s [\"%s\"]", id(groupingKey(prefix)), mermaidText(prefix))
+		for _, d := range schema.Definitions {
+			if d.Source.Prefix == prefix && !d.Duplicate {
+				add("%s[\"%s\"]:::definition", id(d), mermaidText(d.Source.Name))
+				mermaidSchema.addNote(id(d), d.Source.Doc)
+			}
+		}
+		add("end")
+	}
+
+	for _, caveat := range schema.Caveats {
+		if caveat.Duplicate {
+			mermaidSchema.addError("caveat %s is declared more than once", caveat.Name())
+			continue
+		}
+		add("%s{{\"%s\"}}:::caveat", id(caveat), mermaidText(caveat.Source.Signature()))
+		mermaidSchema.addNote(id(caveat), caveat.Source.Doc)
+	}
+
+	// the members of a duplicated definition are not drawn
+	definitions := []*Definition{}
+	for _, d := range schema.Definitions {
+		if !d.Duplicate {
+			definitions = append(definitions, d)
+		}
+	}
+
+	// relations and their subject types
+
+	for _, d := range definitions {
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				mermaidSchema.addError("relation %s is declared more than once in definition %s", r.Name(), d.Name())
+				continue
+			}
+			add("%s([\"%s\"]):::relation", id(r), mermaidText(r.Name()))
+			link("%s --- %s", id(d), id(r))
+			mermaidSchema.addNote(id(r), r.Source.Doc)
+		}
+	}
+	for _, d := range definitions {
+		for _, r := range d.Relations {
+			if r.Duplicate {
+				continue
+			}
+			for _, s := range orderedSubjects(r) {
+				switch {
+				case s.Target == nil:
+					mermaidSchema.addError("definition %s does not exist%s", s.Name, DidYouMean(schema.SuggestDefinitions(s.Name, d)))
+				case s.Kind == SubjectSet && s.TargetRelation == nil:
+					mermaidSchema.addError("%s in definition %s : relation %s does not exist in %s%s", s.Label(), d.Name(), s.RelationName, s.Name, DidYouMean(s.Target.SuggestRelations(s.RelationName)))
+				case s.Duplicate:
+					mermaidSchema.addError("%s is declared more than once in relation %s of definition %s", s.Label(), r.Name(), d.Name())
+				case s.Kind == SubjectSet:
+					link("%s -->|\"%s\"| %s", id(s.TargetRelation), mermaidText(withCaveat(s.Label(), s.CaveatName)), id(r))
+				case s.Kind == WildcardSubject:
+					mermaidSchema.wildcards = append(mermaidSchema.wildcards, mermaidSchema.links)
+					link("%s -->|\"%s\"| %s", id(r), mermaidText(withCaveat("ALL", s.CaveatName)), id(s.Target))
+				case s.CaveatName != "":
+					link("%s -->|\"%s\"| %s", id(r), mermaidText(withCaveat("", s.CaveatName)), id(s.Target))
+				default:
+					link("%s --> %s", id(r), id(s.Target))
+				}
+			}
+
+			drawn := []string{}
+			for _, s := range orderedSubjects(r) {
+				switch {
+				case s.CaveatName == "":
+					// no caveat
+				case s.Caveat == nil:
+					mermaidSchema.addError("caveat %s used in relation %s of definition %s does not exist%s", s.CaveatName, r.Name(), d.Name(), DidYouMean(schema.SuggestCaveats(s.CaveatName)))
+				case !contains(drawn, id(s.Caveat)):
+					drawn = append(drawn, id(s.Caveat))
+					link("%s --- %s", id(r), id(s.Caveat))
+				}
+			}
+		}
+	}
+
+	// permissions and what they use
+
+	for _, d := range definitions {
+		for _, p := range d.Permissions {
+			if p.Duplicate {
+				mermaidSchema.addError("permission %s is declared more than once in definition %s", p.Name(), d.Name())
+				continue
+			}
+			add("%s[\"%s\"]:::permission", id(p), mermaidText(p.Name()+"\n= "+p.Source.Expression.String()))
+			link("%s --- %s", id(d), id(p))
+			mermaidSchema.addNote(id(p), p.Source.Doc)
+			// a name used twice by the expression is drawn once
+			drawn := []string{}
+			for _, ref := range p.References {
+				if contains(drawn, ref.Source.Name) {
+					continue
+				}
+				drawn = append(drawn, ref.Source.Name)
+				switch {
+				case ref.Relation != nil:
+					link("%s -.-> %s", id(p), id(ref.Relation))
+				case ref.Permission != nil:
+					link("%s -.-> %s", id(p), id(ref.Permission))
+				default:
+					mermaidSchema.addError("%s used by permission %s does not exist in definition %s%s", ref.Source.Name, p.Name(), d.Name(), DidYouMean(d.SuggestMembers(ref.Source.Name)))
+				}
+			}
+			for _, arrow := range p.Arrows {
+				if contains(drawn, arrow.Source.String()) {
+					continue
+				}
+				drawn = append(drawn, arrow.Source.String())
+				if arrow.Relation == nil {
+					mermaidSchema.addError("%s used by permission %s is not a relation of definition %s%s", arrow.Source.Relation, p.Name(), d.Name(), DidYouMean(d.SuggestRelations(arrow.Source.Relation)))
+					continue
+				}
+				link("%s -.->|\"%s\"| %s", id(p), mermaidText(arrow.Source.String()), id(arrow.Relation))
+				for _, missing := range arrow.MissingIn {
+					mermaidSchema.addError("%s used by permission %s of definition %s does not exist in %s%s", arrow.Source.Target, p.Name(), d.Name(), missing.Name(), DidYouMean(missing.SuggestMembers(arrow.Source.Target)))
+				}
+			}
+		}
+	}
+
+	add("classDef definition fill:#ffffb5,stroke:#333")
+	add("classDef relation fill:#ffffb5,stroke:#333")
+	add("classDef permission fill:#ffe0b5,stroke:#333")
+	add("classDef caveat fill:#ccccff,stroke:#333")
+	add("classDef note fill:#fffde7,stroke:#999")
+	add("classDef error fill:%s,color:#ffffff,stroke:#990000", options.errorColor("#ff0000"))
+	if options.WildcardColor != "" && len(mermaidSchema.wildcards) > 0 {
+		var numbers []string
+		for _, number := range mermaidSchema.wildcards {
+			numbers = append(numbers, fmt.Sprint(number))
+		}
+		add("linkStyle %s stroke:%s", strings.Join(numbers, ","), options.WildcardColor)
+	}
+	return strings.Join(mermaidSchema.out, "\n") + "\n"
+}
+
+func (mermaidSchema *MermaidSchema) add(format string, args ...interface{}) {
+	mermaidSchema.out = append(mermaidSchema.out, "    "+fmt.Sprintf(format, args...))
+}
+
+// link adds an arrow, counted for linkStyle
+func (mermaidSchema *MermaidSchema) link(format string, args ...interface{}) {
+	mermaidSchema.links++
+	mermaidSchema.add(format, args...)
+}
+
+// an error is a red node eN
+func (mermaidSchema *MermaidSchema) addError(format string, args ...interface{}) {
+	mermaidSchema.errors++
+	mermaidSchema.add("e%d[\"%s\"]:::error", mermaidSchema.errors, mermaidText(fmt.Sprintf(format, args...)))
+}
+
+// a Doc is drawn as a note n_id linked to the element id
+func (mermaidSchema *MermaidSchema) addNote(id string, doc string) {
+	text := CommentText(doc)
+	if mermaidSchema.HideDocs || text == "" {
+		return
+	}
+	mermaidSchema.add("n_%s>\"%s\"]:::note", id, mermaidText(text))
+	mermaidSchema.link("%s -.- n_%s", id, id)
+}
+
+func (mermaidSchema *MermaidSchema) id(element interface{}) string {
+	return mermaidSchema.ids[element]
+}
+
+// mermaidText escapes a label written between double quotes
+func mermaidText(text string) string {
+	replacer := strings.NewReplacer("\"", "#quot;", "<", "#lt;", ">", "#gt;", "\n", "<br/>")
+	return replacer.Replace(text)
+}
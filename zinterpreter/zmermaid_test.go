@@ -0,0 +1,66 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMermaid(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {}
+definition acme/group { relation member: user | user }
+caveat weekday(day int) { day < 6 }
+definition document {
+	// who can view
+	relation viewer: user with weekday | acme/group#member | user:* | usr
+	permission view = viewer + parent->view + nothing
+}
+definition user {}`)
+
+	mydraw := MermaidSchema{Schema: schema}
+	out := mydraw.Generate("doc")
+	for _, line := range []string{
+		"title: \"doc\"",
+		"flowchart LR",
+		"    d_user[\"user\"]:::definition",
+		"    subgraph g_acme [\"acme\"]",
+		"    c_weekday{{\"weekday(day int)\"}}:::caveat",
+		"    r_document_viewer([\"viewer\"]):::relation",
+		"    d_document --- r_document_viewer",
+		"    r_document_viewer -->|\"with weekday\"| d_user",
+		"    r_acme_group_member -->|\"acme/group#member\"| r_document_viewer",
+		"    r_document_viewer -->|\"ALL\"| d_user",
+		"    r_document_viewer --- c_weekday",
+		"    p_document_view -.-> r_document_viewer",
+		"    n_r_document_viewer>\"who can view\"]:::note",
+		"    r_document_viewer -.- n_r_document_viewer",
+		":::error",
+		"[\"definition user is declared more than once\"]:::error",
+		"[\"user is declared more than once in relation member of definition acme/group\"]:::error",
+		"[\"definition usr does not exist, did you mean user?\"]:::error",
+		"[\"parent used by permission view is not a relation of definition document\"]:::error",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in:\n%s", line, out)
+		}
+	}
+
+	mydraw.HideDocs = true
+	if out := mydraw.Generate(""); strings.Contains(out, ":::note") || strings.Contains(out, "title:") {
+		t.Errorf("expected no note and no title in:\n%s", out)
+	}
+}
+
+func TestMermaidText(t *testing.T) {
+	if text := mermaidText("view\n= parent->view \"x\""); text != "view<br/>= parent-#gt;view #quot;x#quot;" {
+		t.Errorf("unexpected text %s", text)
+	}
+}
+
+// the title is quoted in the front matter
+func TestMermaidTitle(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {}`)
+	out := mermaidRenderer{}.Render(schema, RenderOptions{Name: "doc", Title: `IAM: "documents" #1`})
+	if !strings.HasPrefix(out, "---\ntitle: \"IAM: \\\"documents\\\" #1\"\n---\n") {
+		t.Errorf("expected a quoted title in:\n%s", out)
+	}
+}
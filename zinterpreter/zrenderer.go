@@ -0,0 +1,133 @@
+package zinterpreter
+
+// Renderers
+//
+// every output format registers a Renderer in an init function of its file,
+// the commands find them by name, so that a new format does not change them
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RenderOptions are the options given to every renderer, and the "render" part of the configuration file
+//
+//	{ "scale": 0.15, "dpi": 300, "direction": "LR", "legend": true, "skinparams": { "shadowing": "false" } }
+//
+// a field not set keeps the default of the renderer, the options a format can not draw are ignored
+type RenderOptions struct {
+	Name          string            `json:"-"`                       // the name of the diagram, the generated file name without extension
+	HideDocs      bool              `json:"nodocs,omitempty"`        // do not draw the Doc comments
+	Scale         float64           `json:"scale,omitempty"`         // plantuml, 1.0 by default
+	Dpi           int               `json:"dpi,omitempty"`           // plantuml and dot, 96 by default
+	Direction     string            `json:"direction,omitempty"`     // TB, LR, BT or RL, plantuml only knows TB and LR, render rejects BT and RL with it
+	Title         string            `json:"title,omitempty"`         // written above the diagram
+	Legend        bool              `json:"legend,omitempty"`        // plantuml, explains the arrows and the colors
+	ErrorColor    string            `json:"errorColor,omitempty"`    // red by default
+	WildcardColor string            `json:"wildcardColor,omitempty"` // the wildcard arrows
+	SkinParams    map[string]string `json:"skinparams,omitempty"`    // plantuml skinparam name value
+	Sorted        bool              `json:"sorted,omitempty"`        // draw the elements in the order of their names
+}
+
+var (
+	directions       = []string{"TB", "LR", "BT", "RL"}
+	colorPattern     = regexp.MustCompile(`^([a-zA-Z]+|#[0-9a-fA-F]{6}|#[0-9a-fA-F]{3})$`)
+	skinParamPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*$`)
+)
+
+// Validate returns an error for a value no renderer can use
+func (options RenderOptions) Validate() error {
+	switch {
+	case options.Scale < 0:
+		return fmt.Errorf("scale must be positive")
+	case options.Dpi < 0:
+		return fmt.Errorf("dpi must be positive")
+	case options.Direction != "" && !contains(directions, options.Direction):
+		return fmt.Errorf("direction must be one of %s", strings.Join(directions, ", "))
+	case strings.ContainsAny(options.Title, "\r\n"):
+		return fmt.Errorf("title must be on one line")
+	case options.ErrorColor != "" && !knownColor(options.ErrorColor):
+		return fmt.Errorf("errorColor must be a color name or #rrggbb")
+	case options.WildcardColor != "" && !knownColor(options.WildcardColor):
+		return fmt.Errorf("wildcardColor must be a color name or #rrggbb")
+	}
+	names := []string{}
+	for name := range options.SkinParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := options.SkinParams[name]
+		if !skinParamPattern.MatchString(name) {
+			return fmt.Errorf("skinparam %s is not a name", name)
+		}
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("skinparam %s must have a value on one line", name)
+		}
+	}
+	return nil
+}
+
+// the title written above the diagram, its name without title
+func (options RenderOptions) title() string {
+	if options.Title != "" {
+		return options.Title
+	}
+	return options.Name
+}
+
+// the schema to draw, sorted with Sorted
+func (options RenderOptions) schema(schema *Schema) *Schema {
+	if options.Sorted && schema != nil {
+		return schema.Sorted()
+	}
+	return schema
+}
+
+func (options RenderOptions) errorColor(defaultColor string) string {
+	if options.ErrorColor != "" {
+		return options.ErrorColor
+	}
+	return defaultColor
+}
+
+// plantUMLColor writes a color name or #rrggbb as plantUML does, #name or #rrggbb
+func plantUMLColor(color string) string {
+	return "#" + strings.TrimPrefix(color, "#")
+}
+
+// Renderer draws a compiled schema in one format
+type Renderer interface {
+	Name() string      // used by render -format
+	Extension() string // of the generated file, with its dot
+	Render(schema *Schema, options RenderOptions) string
+}
+
+var renderers = make(map[string]Renderer)
+
+// RegisterRenderer makes a renderer available by its name, it panics when the name is taken
+func RegisterRenderer(renderer Renderer) {
+	if _, exists := renderers[renderer.Name()]; exists {
+		panic(fmt.Sprintf("renderer %s is registered more than once", renderer.Name()))
+	}
+	renderers[renderer.Name()] = renderer
+}
+
+// LookupRenderer returns the renderer registered with name, nil if there is none
+func LookupRenderer(name string) Renderer {
+	return renderers[name]
+}
+
+// Renderers returns the registered renderers sorted by name
+func Renderers() []Renderer {
+	var all []Renderer
+	for _, renderer := range renderers {
+		all = append(all, renderer)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+	return all
+}
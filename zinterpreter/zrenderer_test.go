@@ -0,0 +1,119 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderers(t *testing.T) {
+	var names []string
+	for _, renderer := range Renderers() {
+		names = append(names, renderer.Name()+renderer.Extension())
+	}
+	if strings.Join(names, " ") != "archimate.xml dot.dot mermaid.mmd plantuml.puml" {
+		t.Errorf("unexpected renderers %v", names)
+	}
+	if LookupRenderer("svg") != nil {
+		t.Errorf("did not expect a renderer svg")
+	}
+
+	schema, _ := compileInput(t, `definition user {} definition document { relation viewer: user }`)
+	for _, renderer := range Renderers() {
+		out := renderer.Render(schema, RenderOptions{Title: "doc"})
+		if !strings.Contains(out, "doc") || !strings.Contains(out, "viewer") {
+			t.Errorf("%s: expected the title and the relation in:\n%s", renderer.Name(), out)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic registering plantuml twice")
+		}
+	}()
+	RegisterRenderer(plantUMLRenderer{})
+}
+
+func TestRenderOptionsValidate(t *testing.T) {
+	tests := []struct {
+		options  RenderOptions
+		expected string
+	}{
+		{RenderOptions{}, ""},
+		{RenderOptions{Scale: 0.15, Dpi: 300, Direction: "LR", ErrorColor: "#aa0000", WildcardColor: "orange", SkinParams: map[string]string{"shadowing": "false"}}, ""},
+		{RenderOptions{Scale: -1}, "scale must be positive"},
+		{RenderOptions{Direction: "up"}, "direction must be one of TB, LR, BT, RL"},
+		{RenderOptions{Title: "Documents\n@enduml"}, "title must be on one line"},
+		{RenderOptions{ErrorColor: "#red"}, "errorColor must be a color name or #rrggbb"},
+		{RenderOptions{ErrorColor: "LightBlue", WildcardColor: "#0a0"}, ""},
+		{RenderOptions{WildcardColor: "lightblu"}, "wildcardColor must be a color name or #rrggbb"},
+		{RenderOptions{SkinParams: map[string]string{"a b": "1"}}, "skinparam a b is not a name"},
+		{RenderOptions{SkinParams: map[string]string{"dpi": "1\nscale 2"}}, "skinparam dpi must have a value on one line"},
+	}
+	for _, tt := range tests {
+		err := tt.options.Validate()
+		if (err == nil && tt.expected != "") || (err != nil && err.Error() != tt.expected) {
+			t.Errorf("%+v: expected %q, got %v", tt.options, tt.expected, err)
+		}
+	}
+}
+
+func TestPlantUMLOptions(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {} definition document { relation viewer: user:* | usr }`)
+
+	// SchemaScale and SchemaDpi, 1.0 and 96 when they are not set
+	mydraw := PlantUMLArchimateSchema{Schema: schema}
+	if out := mydraw.Generate("doc"); !strings.Contains(out, "scale 1.0\nskinparam dpi 96\n") {
+		t.Errorf("expected the default scale and dpi in:\n%s", out)
+	}
+	mydraw = PlantUMLArchimateSchema{Schema: schema, SchemaScale: 0.15, SchemaDpi: 300}
+	if out := mydraw.Generate("doc"); !strings.Contains(out, "scale 0.15\nskinparam dpi 300\n") {
+		t.Errorf("expected scale 0.15 and dpi 300 in:\n%s", out)
+	}
+
+	options := RenderOptions{Name: "doc", Scale: 2, Direction: "LR", Title: "Documents", Legend: true, ErrorColor: "#aa0000", WildcardColor: "orange", SkinParams: map[string]string{"shadowing": "false", "ArrowColor": "blue"}}
+	out := plantUMLRenderer{}.Render(schema, options)
+	for _, line := range []string{
+		"@startuml doc\n",
+		"scale 2.0\nskinparam dpi 96\nleft to right direction\nskinparam ArrowColor blue\nskinparam shadowing false\ntitle Documents\n",
+		"r_document_viewer .[#orange].> d_user : \"ALL\"",
+		"rectangle \"definition usr does not exist, did you mean user? \" #aa0000",
+		"legend right\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in:\n%s", line, out)
+		}
+	}
+
+	out = mermaidRenderer{}.Render(schema, options)
+	for _, line := range []string{"title: \"Documents\"", "flowchart LR", "classDef error fill:#aa0000", "linkStyle 1 stroke:orange"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in:\n%s", line, out)
+		}
+	}
+}
+
+// a relation used twice by a permission is drawn once
+func TestRenderReferencesOnce(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {}
+definition doc {
+	relation viewer: user
+	relation owner: user
+	relation parent: doc
+	permission view = viewer + owner - (viewer & owner) + parent->view + parent->view
+}`)
+	for _, tt := range []struct {
+		renderer Renderer
+		edge     string
+	}{
+		{plantUMLRenderer{}, "Rel_Aggregation(p_doc_view,r_doc_viewer)"},
+		{plantUMLRenderer{}, "Rel_Aggregation(p_doc_view,r_doc_parent,\"parent->view\")"},
+		{mermaidRenderer{}, "p_doc_view -.-> r_doc_owner"},
+		{mermaidRenderer{}, "p_doc_view -.->|\"parent-#gt;view\"| r_doc_parent"},
+		{archimateExchangeRenderer{}, `source="id-p_doc_view" target="id-r_doc_viewer"`},
+	} {
+		out := tt.renderer.Render(schema, RenderOptions{Name: "doc"})
+		if count := strings.Count(out, tt.edge); count != 1 {
+			t.Errorf("%s: expected %s once, got %d in:\n%s", tt.renderer.Name(), tt.edge, count, out)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package zinterpreter
+
+// Sorted schemas
+//
+// the diagrams draw the elements in the order of the schema,
+// a sorted copy draws them in the order of their names, whatever the order they are written in
+
+import (
+	"sort"
+)
+
+// Sorted returns a copy of the schema with its definitions and caveats sorted by name,
+// and the relations, permissions and subject types of each definition sorted by name
+// duplicates stay after the element they duplicate, the schema is not changed
+func (s *Schema) Sorted() *Schema {
+	kept := make(map[*Definition][]*Relation)
+	expanded := make(map[*Definition]bool)
+	for _, d := range s.Definitions {
+		kept[d] = nil
+		expanded[d] = true
+	}
+	sorted := s.subSchema(kept, expanded, nil, nil)
+
+	sort.SliceStable(sorted.Definitions, func(i, j int) bool {
+		return sorted.Definitions[i].Name() < sorted.Definitions[j].Name()
+	})
+	for _, d := range sorted.Definitions {
+		sort.SliceStable(d.Relations, func(i, j int) bool {
+			return d.Relations[i].Name() < d.Relations[j].Name()
+		})
+		sort.SliceStable(d.Permissions, func(i, j int) bool {
+			return d.Permissions[i].Name() < d.Permissions[j].Name()
+		})
+		for _, r := range d.Relations {
+			sort.SliceStable(r.Subjects, func(i, j int) bool {
+				return r.Subjects[i].Label() < r.Subjects[j].Label()
+			})
+		}
+	}
+
+	// the unused caveats are kept too
+	sorted.Caveats = append([]*Caveat(nil), s.Caveats...)
+	sort.SliceStable(sorted.Caveats, func(i, j int) bool {
+		return sorted.Caveats[i].Name() < sorted.Caveats[j].Name()
+	})
+	for _, caveat := range sorted.Caveats {
+		if !caveat.Duplicate {
+			sorted.caveatMap[caveat.Name()] = caveat
+		}
+	}
+	return sorted
+}
@@ -0,0 +1,67 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSorted(t *testing.T) {
+	schema, _ := compileInput(t, `caveat weekday(day int) { day < 6 }
+definition user {}
+definition document {
+	relation viewer: user:* | group#member | user with weekday
+	permission view = viewer + owner
+	relation owner: user
+	permission edit = owner
+}
+definition group { relation member: user }
+caveat after(day int) { day > 1 }
+definition user {}`)
+
+	sorted := schema.Sorted()
+	var names []string
+	for _, d := range sorted.Definitions {
+		names = append(names, d.Name())
+	}
+	if strings.Join(names, " ") != "document group user user" || !sorted.Definitions[3].Duplicate {
+		t.Errorf("unexpected definitions %v", names)
+	}
+	if sorted.Definition("user") != sorted.Definitions[2] {
+		t.Errorf("expected user to be the first user")
+	}
+
+	document := sorted.Definition("document")
+	if document.Relations[0].Name() != "owner" || document.Permissions[0].Name() != "edit" {
+		t.Errorf("expected the relations and permissions sorted by name")
+	}
+	var labels []string
+	for _, subject := range document.Relation("viewer").Subjects {
+		labels = append(labels, subject.Label())
+	}
+	if strings.Join(labels, " ") != "group#member user user:*" {
+		t.Errorf("unexpected subjects %v", labels)
+	}
+	if document.Relation("viewer").Subjects[0].TargetRelation != sorted.Definition("group").Relation("member") {
+		t.Errorf("expected the subject set to refer to the sorted schema")
+	}
+	if len(sorted.Caveats) != 2 || sorted.Caveats[0].Name() != "after" || sorted.Caveat("weekday") == nil {
+		t.Errorf("expected every caveat sorted by name")
+	}
+
+	// the schema is not changed
+	if schema.Definitions[0].Name() != "user" || schema.Definition("document").Relations[0].Name() != "viewer" {
+		t.Errorf("did not expect the schema to be sorted")
+	}
+}
+
+func TestSortedRender(t *testing.T) {
+	first, _ := compileInput(t, `definition user {} definition doc { relation viewer: user relation owner: user }`)
+	second, _ := compileInput(t, `definition doc { relation owner: user relation viewer: user } definition user {}`)
+
+	for _, renderer := range Renderers() {
+		options := RenderOptions{Name: "doc", Sorted: true}
+		if a, b := renderer.Render(first, options), renderer.Render(second, options); a != b {
+			t.Errorf("%s: expected the same sorted diagram:\n%s", renderer.Name(), UnifiedDiff("first", "second", a, b))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package zinterpreter
+
+// Split diagrams
+//
+// for a large schema, SplitPlantUML draws one diagram per definition, with its relations and the definitions they use,
+// and an index of the definitions only, with an arrow from a definition to the definitions its relations use
+// every definition links to its own diagram, and in its own diagram to the index, as [[name.svg]]
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiagramFile is a generated file
+type DiagramFile struct {
+	Name    string
+	Content string
+}
+
+// SplitIndexName is the file name of the index, without extension
+// a definition can not have it : a name has no '-'
+const SplitIndexName = "schema-index"
+
+// SplitFileName is the file name of the diagram of a definition, without extension
+// the '/' of the prefix is replaced by '.', that a name can not contain
+func SplitFileName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// SplitPlantUML returns schema-index.puml then a .puml file for each definition, in the order of the schema
+func SplitPlantUML(schema *Schema, options RenderOptions) []DiagramFile {
+	schema = options.schema(schema)
+	links := make(map[string]string)
+	for _, d := range schema.Definitions {
+		if !d.Duplicate {
+			links[d.Name()] = SplitFileName(d.Name()) + ".svg"
+		}
+	}
+
+	index := PlantUMLArchimateSchema{Schema: schema, SchemaDpi: options.Dpi, SchemaScale: options.Scale, HideDocs: options.HideDocs, Options: options, Links: links}
+	files := []DiagramFile{{SplitIndexName + ".puml", index.GenerateIndex(SplitIndexName)}}
+
+	for _, d := range schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		focused, _ := schema.Focus(d.Name(), 1, false)
+		ownLinks := make(map[string]string)
+		for name, url := range links {
+			ownLinks[name] = url
+		}
+		ownLinks[d.Name()] = SplitIndexName + ".svg"
+		definitionOptions := options
+		definitionOptions.Title = d.Name()
+		definitionOptions.Legend = false
+		mydraw := PlantUMLArchimateSchema{Schema: focused, SchemaDpi: options.Dpi, SchemaScale: options.Scale, HideDocs: options.HideDocs, Options: definitionOptions, Links: ownLinks}
+		name := SplitFileName(d.Name())
+		files = append(files, DiagramFile{name + ".puml", mydraw.Generate(name)})
+	}
+	return files
+}
+
+// GenerateIndex draws the definitions only, with an arrow from a definition to each definition its relations use
+func (plantUMLArchimateSchema *PlantUMLArchimateSchema) GenerateIndex(pngfilename string) string {
+	var out []string
+	plantUMLArchimateSchema.createIDforZdef()
+	id := plantUMLArchimateSchema.id
+
+	out = plantUMLArchimateSchema.appendHeader(out, pngfilename)
+	out = plantUMLArchimateSchema.appendDefinitions(out)
+	for _, d := range plantUMLArchimateSchema.Schema.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		drawn := []string{}
+		for _, r := range d.Relations {
+			for _, s := range orderedSubjects(r) {
+				if s.Target != nil && s.Target != d && !contains(drawn, id(s.Target)) {
+					drawn = append(drawn, id(s.Target))
+					out = append(out, fmt.Sprintf("Rel_Association(%s,%s)", id(d), id(s.Target)))
+				}
+			}
+		}
+	}
+	out = plantUMLArchimateSchema.appendFooter(out)
+	return strings.Join(out, "\n")
+}
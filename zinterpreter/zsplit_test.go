@@ -0,0 +1,74 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitPlantUML(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {}
+definition acme/group { relation member: user | acme/group#member }
+definition document { relation viewer: user | acme/group#member }
+definition document {}`)
+
+	files := SplitPlantUML(schema, RenderOptions{Name: "doc"})
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	if strings.Join(names, " ") != "schema-index.puml user.puml acme.group.puml document.puml" {
+		t.Fatalf("unexpected files %v", names)
+	}
+
+	index := files[0].Content
+	for _, line := range []string{
+		"@startuml schema-index\n",
+		"archimate #Business \"user\" as d_user <<business-object>> [[user.svg]]",
+		"archimate #Business \"group\" as d_acme_group <<business-object>> [[acme.group.svg]]",
+		"rectangle \"definition document is declared more than one \" #red",
+		"Rel_Association(d_acme_group,d_user)\nRel_Association(d_document,d_user)\nRel_Association(d_document,d_acme_group)\n@enduml",
+	} {
+		if !strings.Contains(index, line) {
+			t.Errorf("expected %s in the index:\n%s", line, index)
+		}
+	}
+	if strings.Contains(index, "<<relation>>") {
+		t.Errorf("did not expect a relation in the index:\n%s", index)
+	}
+
+	document := files[3].Content
+	for _, line := range []string{
+		"@startuml document\n",
+		"title document\n",
+		"archimate #Business \"document\" as d_document <<business-object>> [[schema-index.svg]]",
+		"archimate #Business \"group\" as d_acme_group <<business-object>> [[acme.group.svg]]",
+		"Business_Object(r_document_viewer,\"viewer\") <<relation>>",
+		"Rel_Access_w(r_acme_group_member,r_document_viewer,\"acme/group#member\")",
+	} {
+		if !strings.Contains(document, line) {
+			t.Errorf("expected %s in the diagram of document:\n%s", line, document)
+		}
+	}
+	if strings.Contains(document, "#red") {
+		t.Errorf("did not expect an error in the diagram of document:\n%s", document)
+	}
+}
+
+// a definition named index does not replace the index
+func TestSplitIndexName(t *testing.T) {
+	schema, _ := compileInput(t, `definition user {} definition index { relation owner: user }`)
+	files := SplitPlantUML(schema, RenderOptions{Name: "doc"})
+	names := make(map[string]bool)
+	for _, file := range files {
+		if names[file.Name] {
+			t.Errorf("%s written twice", file.Name)
+		}
+		names[file.Name] = true
+	}
+	if !names["schema-index.puml"] || !names["index.puml"] {
+		t.Errorf("expected schema-index.puml and index.puml, got %v", names)
+	}
+	if !strings.Contains(files[2].Content, "as d_index <<business-object>> [[schema-index.svg]]") {
+		t.Errorf("expected the diagram of index to link to the index:\n%s", files[2].Content)
+	}
+}
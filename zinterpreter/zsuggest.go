@@ -0,0 +1,151 @@
+package zinterpreter
+
+// Suggestions for the unknown names
+//
+// a name not found is compared with the existing names by edit distance,
+// the closest ones are given as "did you mean ...?"
+
+import (
+	"sort"
+	"strings"
+)
+
+const maxSuggestions = 3
+
+// levenshtein returns the number of runes to insert, delete or replace to go from a to b
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// suggest returns the candidates closest to name, the closest first
+// a candidate is kept when at most a third of name, and at least one rune, has to change
+func suggest(name string, candidates []string) []string {
+	limit := len([]rune(name)) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var kept []candidate
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if c == name || seen[c] {
+			continue
+		}
+		seen[c] = true
+		if distance := levenshtein(strings.ToLower(name), strings.ToLower(c)); distance <= limit {
+			kept = append(kept, candidate{c, distance})
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		if kept[i].distance != kept[j].distance {
+			return kept[i].distance < kept[j].distance
+		}
+		return kept[i].name < kept[j].name
+	})
+
+	var suggestions []string
+	for _, c := range kept {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, c.name)
+	}
+	return suggestions
+}
+
+// DidYouMean returns ", did you mean a, b or c?", or "" without suggestion
+func DidYouMean(suggestions []string) string {
+	switch len(suggestions) {
+	case 0:
+		return ""
+	case 1:
+		return ", did you mean " + suggestions[0] + "?"
+	default:
+		return ", did you mean " + strings.Join(suggestions[:len(suggestions)-1], ", ") + " or " + suggestions[len(suggestions)-1] + "?"
+	}
+}
+
+// SuggestDefinitions returns the definitions whose name is close to name, as written in from
+// a definition with the prefix of from is also suggested without its prefix
+func (s *Schema) SuggestDefinitions(name string, from *Definition) []string {
+	var candidates []string
+	for _, d := range s.Definitions {
+		if d.Duplicate {
+			continue
+		}
+		if from != nil && from.Source.Prefix != "" && d.Source.Prefix == from.Source.Prefix && !strings.Contains(name, "/") {
+			candidates = append(candidates, d.Source.Name)
+		} else {
+			candidates = append(candidates, d.Name())
+		}
+	}
+	return suggest(name, candidates)
+}
+
+// SuggestCaveats returns the caveats whose name is close to name
+func (s *Schema) SuggestCaveats(name string) []string {
+	var candidates []string
+	for _, c := range s.Caveats {
+		if !c.Duplicate {
+			candidates = append(candidates, c.Name())
+		}
+	}
+	return suggest(name, candidates)
+}
+
+// SuggestRelations returns the relations of the definition whose name is close to name
+func (d *Definition) SuggestRelations(name string) []string {
+	var candidates []string
+	for _, r := range d.Relations {
+		if !r.Duplicate {
+			candidates = append(candidates, r.Name())
+		}
+	}
+	return suggest(name, candidates)
+}
+
+// SuggestMembers returns the relations and the permissions of the definition whose name is close to name
+func (d *Definition) SuggestMembers(name string) []string {
+	var candidates []string
+	for _, r := range d.Relations {
+		if !r.Duplicate {
+			candidates = append(candidates, r.Name())
+		}
+	}
+	for _, p := range d.Permissions {
+		if !p.Duplicate {
+			candidates = append(candidates, p.Name())
+		}
+	}
+	return suggest(name, candidates)
+}
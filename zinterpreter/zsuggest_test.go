@@ -0,0 +1,84 @@
+package zinterpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"user", "user", 0},
+		{"usr", "user", 1},
+		{"grup", "group", 1},
+		{"doucment", "document", 2},
+		{"", "abc", 3},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if d := levenshtein(tt.a, tt.b); d != tt.expected {
+			t.Errorf("levenshtein(%s, %s) expected %d, got %d", tt.a, tt.b, tt.expected, d)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"spanner_databases_get", "spanner_databases_list", "spanner_databases_getddl", "spanner_sessions_get", "user"}
+	if s := suggest("spanner_databases_gett", candidates); len(s) != 3 || s[0] != "spanner_databases_get" {
+		t.Errorf("unexpected suggestions %v", s)
+	}
+	if s := suggest("grp", []string{"group", "user"}); len(s) != 0 {
+		t.Errorf("expected no suggestion too far from grp, got %v", s)
+	}
+	if DidYouMean(nil) != "" || DidYouMean([]string{"a"}) != ", did you mean a?" || DidYouMean([]string{"a", "b", "c"}) != ", did you mean a, b or c?" {
+		t.Errorf("unexpected did you mean")
+	}
+}
+
+func TestSuggestionsInDiagnostics(t *testing.T) {
+	input := `caveat weekday(day int) { day < 6 }
+definition user {}
+definition acme/group { relation member: user }
+definition acme/document {
+	relation viewer: usr | grup#member | group#membr with weekdy
+	relation owner: user
+	permission view = viewr + owner->nam + ownr->view
+}`
+	lexer := NewLexer(input)
+	lexer.NextToken()
+	z, err := lexer.ReadZSchema()
+	if err != nil {
+		t.Fatalf("did not expect an error: %v", err)
+	}
+	_, diagnostics := Compile(z, lexer.ZCaveats())
+
+	expected := [][]string{
+		{"user"},
+		{"group"}, // in acme/document, acme/group is written group
+		{"member"},
+		{"weekday"},
+		{"view", "viewer"},
+		nil, // nam : user has no relation
+		{"owner"},
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), diagnostics)
+	}
+	for i, suggestions := range expected {
+		if strings.Join(diagnostics[i].Suggestions, ",") != strings.Join(suggestions, ",") {
+			t.Errorf("expected %v, got %v in %s", suggestions, diagnostics[i].Suggestions, diagnostics[i].Message)
+		}
+	}
+	if !strings.HasSuffix(diagnostics[0].Message, "does not exist, did you mean user?") {
+		t.Errorf("unexpected message %s", diagnostics[0].Message)
+	}
+
+	mydraw := PlantUMLArchimateSchema{Zdefs: z, Zcaveats: lexer.ZCaveats()}
+	out := mydraw.Generate("suggest")
+	if !strings.Contains(out, "rectangle \"definition usr does not exist, did you mean user? \" #red") {
+		t.Errorf("expected a suggestion in the red rectangle:\n%s", out)
+	}
+}
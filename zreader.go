@@ -4,33 +4,123 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"zreader4/zinterpreter"
+	"strings"
 )
 
+// exit codes
+const (
+	exitOK        = 0
+	exitSyntax    = 1 // the schema has a syntax error
+	exitSemantic  = 2 // the schema has a semantic error
+	exitWarnings  = 3 // the schema has a warning and -werror is set
+	exitIO        = 4 // a file can not be read or written
+	exitUsage     = 5 // the command or the options are wrong
+	exitDifferent = 6 // fmt -d : the schema is not formatted, diff : the schemas are different
+)
+
+// zreader <command> [options] [args]
+// flags declares the options of the command and returns the function running it with the positional arguments
+// the function returns the exit code
+type command struct {
+	name    string
+	args    string // positional arguments in the usage line
+	summary string
+	flags   func(fs *flag.FlagSet) func(args []string) int
+}
+
+var commands = []*command{
+	parseCommand,
+	validateCommand,
+	renderCommand,
+	fmtCommand,
+	diffCommand,
+	lintCommand,
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
 func printHelp() {
 	fmt.Println("2024 : See my blog https://jeandi7.github.io/jeandi7blog/")
 	fmt.Println()
-	fmt.Println("Usage: zreader [options]")
-	fmt.Println("Options:")
-	flag.PrintDefaults()
-	os.Exit(0)
+	fmt.Println("Usage: zreader <command> [options]")
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println("  help      Show the help of a command")
+	fmt.Println()
+	fmt.Println("zreader [options] without command renders the schema, as zreader render.")
+	fmt.Println("Use zreader help <command> for the options of a command.")
+	fmt.Println()
+	printExitCodes()
 }
 
-func writeOutFile(content string, outname string) {
-	filename := outname + ".puml"
+func printExitCodes() {
+	fmt.Println("Exit codes:")
+	fmt.Println("  0 the schema is valid")
+	fmt.Println("  1 the schema has a syntax error")
+	fmt.Println("  2 the schema has a semantic error")
+	fmt.Println("  3 the schema has a warning and -werror is set")
+	fmt.Println("  4 a file can not be read or written")
+	fmt.Println("  5 the command or the options are wrong")
+	fmt.Println("  6 the schema is not formatted (fmt -d), the schemas are different (diff)")
+}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		fmt.Println("Erreur lors de la création du fichier:", err)
-		return
+// flagSet of a command : the flag errors exit with exitUsage instead of 2
+func (cmd *command) flagSet() (*flag.FlagSet, func(args []string) int) {
+	fs := flag.NewFlagSet("zreader "+cmd.name, flag.ContinueOnError)
+	run := cmd.flags(fs)
+	fs.Usage = func() {
+		fmt.Println(strings.TrimSpace(fmt.Sprintf("Usage: zreader %s [options] %s", cmd.name, cmd.args)))
+		fmt.Println(cmd.summary)
+		fmt.Println("Options:")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+		fs.SetOutput(nil)
 	}
-	defer file.Close() // Assurer la fermeture du fichier à la fin du programme
+	return fs, run
+}
 
-	_, err = file.WriteString(content)
+func (cmd *command) run(args []string) int {
+	fs, run := cmd.flagSet()
+	positional, err := parseFlags(fs, args)
 	if err != nil {
-		fmt.Println("Erreur lors de l'écriture dans le fichier:", err)
-		return
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
 	}
+	return run(positional)
+}
+
+// parseFlags accepts the options before and after the positional arguments, as in zreader fmt schemas/ -d
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// usage error of a command : the message and the help of the command
+func usage(fs *flag.FlagSet, message string) int {
+	fmt.Fprintln(os.Stderr, message)
+	fs.Usage()
+	return exitUsage
 }
 
 func main() {
@@ -43,56 +133,39 @@ func main() {
 	// input := `definition monsujet { } definition monsujet2 { } definition maressource { relation marelation: monsujet | monsujet2   }`
 	// input := `definition monsujet { } definition monsujet2 { } definition maressource { relation marelation: monsujet | monsujet2  relation mr2: monsujet | msj3  }`
 
-	var input string = ""
-	var schema string = ""
-	var fschema string = ""
-	var out string = ""
-	var showHelp bool
-
-	flag.StringVar(&schema, "schema", "", "Read schema")
-	flag.StringVar(&fschema, "fschema", "", "Read schema file")
-	flag.StringVar(&out, "out", "out", "Archimate plantUML generated file name")
-	flag.BoolVar(&showHelp, "help", false, "Show help message")
-	flag.Parse()
+	os.Exit(run(os.Args[1:]))
+}
 
-	if (schema == "" && fschema == "") || (schema != "" && fschema != "") {
-		fmt.Println("you must provide either -schema or -fschema, but not both.")
+// run runs the command line without the program name and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 {
 		printHelp()
-		return
+		return exitUsage
 	}
 
-	if schema != "" {
-		input = schema
+	// zreader -fschema ... is the command line of the first versions
+	if strings.HasPrefix(args[0], "-") {
+		return runLegacy(args)
 	}
 
-	if fschema != "" {
-		fileContent, err := os.ReadFile(fschema)
-		if err != nil {
-			fmt.Println("Erreur lors de la lecture du fichier : ", err)
+	switch args[0] {
+	case "help":
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				fs, _ := cmd.flagSet()
+				fs.Usage()
+				return exitOK
+			}
 		}
-		input = string(fileContent)
-	}
-
-	if showHelp {
 		printHelp()
-		return
+		return exitOK
 	}
 
-	lexer := zinterpreter.NewLexer(input)
-	lexer.NextToken()
-	zschema, err := lexer.ReadZSchema()
-
-	if err != nil {
-		fmt.Println("syntax error:", err)
-	} else {
-		// fmt.Println("parsed schema OK:", zschema)
-		fmt.Println("parsed schema is done.")
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", args[0])
+		printHelp()
+		return exitUsage
 	}
-
-	mydraw := zinterpreter.PlantUMLArchimateSchema{Zdefs: zschema}
-	archimatePlantUml := mydraw.Generate(out)
-
-	writeOutFile(archimatePlantUml, out)
-	fmt.Println("Generating " + out + ".puml is done.")
-
+	return cmd.run(args[1:])
 }
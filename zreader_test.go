@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// every exit code : its value, its line in the README table and a command line exiting with it
+func TestExitCodes(t *testing.T) {
+	dir := writeFiles(t, testSchemas)
+	in := func(name string) string { return filepath.Join(dir, name) }
+	readme, err := os.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		code     int
+		expected int
+		readme   string
+		args     []string
+	}{
+		{exitOK, 0, "the schema is valid", []string{"validate", in("valid.zed")}},
+		{exitSyntax, 1, "the schema has a syntax error", []string{"validate", in("syntax.zed")}},
+		{exitSemantic, 2, "the schema has a semantic error", []string{"validate", in("semantic.zed")}},
+		{exitWarnings, 3, "the schema has a warning and `-werror` is set", []string{"validate", "-werror", in("warning.zed")}},
+		{exitIO, 4, "a file can not be read or written", []string{"validate", in("missing.zed")}},
+		{exitUsage, 5, "the command or the options are wrong", []string{"validate", "-unknown", in("valid.zed")}},
+		{exitDifferent, 6, "with `fmt -d`, the schema is not formatted, with `diff`, the schemas are different", []string{"fmt", "-d", in("unformatted.zed")}},
+	}
+	for _, test := range tests {
+		if test.code != test.expected {
+			t.Errorf("expected the exit code %d, got %d", test.expected, test.code)
+		}
+		row := fmt.Sprintf("| %d | %s |", test.expected, test.readme)
+		if !strings.Contains(strings.ReplaceAll(string(readme), "\r\n", "\n"), row) {
+			t.Errorf("expected the README row %q", row)
+		}
+		if code := runQuiet(t, test.args...); code != test.expected {
+			t.Errorf("%s: expected the exit code %d, got %d", strings.Join(test.args, " "), test.expected, code)
+		}
+	}
+}